@@ -0,0 +1,60 @@
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"runtime"
+)
+
+// CanonicalConfig is the config of canonical (line) mode, where input is
+// assembled into lines by the kernel and each Read returns at most one line,
+// lines are terminated by '\n', EOL or EOL2, and special characters
+// are disabled when set to 0
+type CanonicalConfig struct {
+	// EOF ends the line without itself (usually 0x04, ^D),
+	// Read returns 0 bytes (io.EOF) if it's at the beginning of a line
+	EOF byte
+	// EOL and EOL2 are additional line terminators (e.g. '\r')
+	EOL  byte
+	EOL2 byte
+	// Erase erases the last character of the line (usually 0x7f, DEL)
+	Erase byte
+	// Kill erases the whole line (usually 0x15, ^U)
+	Kill byte
+
+	// Echo input characters back to the device
+	Echo bool
+}
+
+// WithCanonicalMode enable canonical (line) mode with config,
+// nil config disables it (raw mode, the default)
+// not supported on windows
+func WithCanonicalMode(config *CanonicalConfig) Option {
+	return func(c *SerialPort) error {
+		if config != nil && runtime.GOOS == "windows" {
+			return ErrNotSupported
+		}
+
+		if config != nil {
+			copied := *config
+			config = &copied
+		}
+
+		c.canonical = config
+		return nil
+	}
+}
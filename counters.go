@@ -0,0 +1,106 @@
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"sync"
+)
+
+// Counters are statistics of serial port
+type Counters struct {
+	// Driver reports whether counters of the driver are available,
+	// they are zero if not (e.g. on pty or platforms other than linux)
+	Driver bool
+
+	// counted by the driver, 32-bit counters wrap around
+	RX         uint32
+	TX         uint32
+	Frame      uint32
+	Parity     uint32
+	Overrun    uint32
+	BufOverrun uint32
+	Break      uint32
+
+	// counted by this library since the port opened
+	BytesRead     uint64
+	BytesWritten  uint64
+	ReadTimeouts  uint64
+	WriteTimeouts uint64
+}
+
+// Delta returns the increase of counters since prev, for periodic reporting
+func (c Counters) Delta(prev Counters) Counters {
+	return Counters{
+		Driver: c.Driver && prev.Driver,
+
+		RX:         c.RX - prev.RX,
+		TX:         c.TX - prev.TX,
+		Frame:      c.Frame - prev.Frame,
+		Parity:     c.Parity - prev.Parity,
+		Overrun:    c.Overrun - prev.Overrun,
+		BufOverrun: c.BufOverrun - prev.BufOverrun,
+		Break:      c.Break - prev.Break,
+
+		BytesRead:     c.BytesRead - prev.BytesRead,
+		BytesWritten:  c.BytesWritten - prev.BytesWritten,
+		ReadTimeouts:  c.ReadTimeouts - prev.ReadTimeouts,
+		WriteTimeouts: c.WriteTimeouts - prev.WriteTimeouts,
+	}
+}
+
+// portCounters are counters maintained by this library
+type portCounters struct {
+	mu sync.Mutex
+	Counters
+}
+
+// Counters returns driver counters (TIOCGICOUNT) merged with library counters,
+// Driver is false without error if driver counters are not available
+func (s *SerialPort) Counters() (Counters, error) {
+	s.counters.mu.Lock()
+	c := s.counters.Counters
+	s.counters.mu.Unlock()
+
+	if err := s.getDriverCounters(&c); err != nil {
+		return Counters{}, err
+	}
+	return c, nil
+}
+
+// countRead updates library counters with result of Read
+func (s *SerialPort) countRead(n int, err error) {
+	timeout := err == ErrTimeout || err == errReadTimeout
+
+	s.counters.mu.Lock()
+	s.counters.BytesRead += uint64(n)
+	if timeout {
+		s.counters.ReadTimeouts++
+	}
+	s.counters.mu.Unlock()
+}
+
+// countWrite updates library counters with result of Write
+func (s *SerialPort) countWrite(n int, err error) {
+	_, timeout := err.(*WriteTimeoutError)
+
+	s.counters.mu.Lock()
+	s.counters.BytesWritten += uint64(n)
+	if timeout {
+		s.counters.WriteTimeouts++
+	}
+	s.counters.mu.Unlock()
+}
@@ -0,0 +1,266 @@
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrTimeout happens when read deadline exceeded,
+// it satisfies net.Error with Timeout() returning true
+var ErrTimeout error = timeoutError{}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "serial port i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// errReadTimeout is returned by read when read timeout set by
+// WithReadTimeout exceeded, Read reports it as io.EOF
+var errReadTimeout = errors.New("serial port read timeout")
+
+// WriteTimeoutError happens when write deadline or write timeout exceeded
+// before all bytes written, it satisfies net.Error with Timeout() returning true
+type WriteTimeoutError struct {
+	// Written is the count of bytes have been written
+	Written int
+}
+
+func (e *WriteTimeoutError) Error() string {
+	return fmt.Sprintf("serial port write timeout, %d bytes written", e.Written)
+}
+
+// Timeout is always true for WriteTimeoutError
+func (e *WriteTimeoutError) Timeout() bool { return true }
+
+// Temporary is always true for WriteTimeoutError
+func (e *WriteTimeoutError) Temporary() bool { return true }
+
+// aLongTimeAgo is a deadline in the past used to interrupt blocking operations
+var aLongTimeAgo = time.Unix(1, 0)
+
+// SetDeadline sets both read and write deadlines like net.Conn,
+// zero value of t means no deadline
+func (s *SerialPort) SetDeadline(t time.Time) error {
+	if err := s.SetReadDeadline(t); err != nil {
+		return err
+	}
+
+	return s.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets deadline for pending and future Read calls,
+// ErrTimeout is returned by Read once deadline exceeded,
+// read timeout set by WithReadTimeout is ignored while deadline is set
+func (s *SerialPort) SetReadDeadline(t time.Time) error {
+	s.deadlineMu.Lock()
+	defer s.deadlineMu.Unlock()
+
+	if err := s.setReadDeadline(t); err != nil {
+		return err
+	}
+
+	s.readDeadline = t
+	return nil
+}
+
+// SetWriteDeadline sets deadline for pending and future Write calls,
+// WriteTimeoutError is returned by Write once deadline exceeded,
+// write timeout set by WithWriteTimeout is ignored while deadline is set
+func (s *SerialPort) SetWriteDeadline(t time.Time) error {
+	s.deadlineMu.Lock()
+	defer s.deadlineMu.Unlock()
+
+	if err := s.setWriteDeadline(t); err != nil {
+		return err
+	}
+
+	s.writeDeadline = t
+	return nil
+}
+
+// ReadContext reads bytes like Read, but returns ctx.Err() once ctx is done,
+// the ctx deadline is used as read deadline if it's earlier than current one
+func (s *SerialPort) ReadContext(ctx context.Context, data []byte) (int, error) {
+	return s.doContext(ctx, &s.readDeadline, s.setReadDeadline, &s.pendingRead, func() (int, error) {
+		return s.Read(data)
+	})
+}
+
+// WriteContext writes bytes like Write, but returns ctx.Err() once ctx is done,
+// the ctx deadline is used as write deadline if it's earlier than current one
+func (s *SerialPort) WriteContext(ctx context.Context, data []byte) (int, error) {
+	return s.doContext(ctx, &s.writeDeadline, s.setWriteDeadline, &s.pendingWrite, func() (int, error) {
+		return s.Write(data)
+	})
+}
+
+// doContext performs op with deadline derived from ctx, and interrupts op
+// by setting a deadline in the past and canceling the pending operation
+// when ctx is done, the deadline before op is restored after op unless
+// it has been changed during op
+func (s *SerialPort) doContext(ctx context.Context, deadline *time.Time,
+	setDeadline func(t time.Time) error, pending *pendingIO, op func() (int, error)) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	// ctx can never be done
+	if ctx.Done() == nil {
+		return op()
+	}
+
+	// saved is the deadline to restore, ours is the deadline in effect
+	// which was set by doContext, both are guarded by deadlineMu
+	var saved, ours time.Time
+	set := func(t time.Time) error {
+		if err := setDeadline(t); err != nil {
+			return err
+		}
+
+		*deadline, ours = t, t
+		return nil
+	}
+
+	s.deadlineMu.Lock()
+	saved = *deadline
+	ours = saved
+	if d, ok := ctx.Deadline(); ok && (saved.IsZero() || d.Before(saved)) {
+		if err := set(d); err != nil {
+			s.deadlineMu.Unlock()
+			return 0, err
+		}
+	}
+	s.deadlineMu.Unlock()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		select {
+		case <-ctx.Done():
+			s.deadlineMu.Lock()
+			if !deadline.Equal(ours) {
+				// changed by caller during op
+				saved = *deadline
+			}
+			set(aLongTimeAgo)
+			s.deadlineMu.Unlock()
+
+			pending.cancelPending()
+		case <-done:
+		}
+	}()
+
+	n, err := op()
+	close(done)
+	<-stopped
+
+	s.deadlineMu.Lock()
+	if deadline.Equal(ours) && !ours.Equal(saved) {
+		set(saved)
+	}
+	s.deadlineMu.Unlock()
+
+	if err != nil && ctx.Err() != nil {
+		return n, ctx.Err()
+	}
+
+	return n, err
+}
+
+// pendingIO keeps the cancel function of a pending read or write, to cancel
+// the operation alone (windows only, deadlines interrupt operations on posix)
+type pendingIO struct {
+	mu     sync.Mutex
+	cancel func()
+}
+
+// set replaces the cancel function, nil means no pending operation
+func (p *pendingIO) set(cancel func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.cancel = cancel
+}
+
+// cancelPending cancels the pending operation if any
+func (p *pendingIO) cancelPending() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cancel != nil {
+		p.cancel()
+	}
+}
+
+// pendingCall keeps at most one call of a blocking function running in
+// background, for functions can not be interrupted (e.g. TIOCMIWAIT, tcdrain)
+type pendingCall struct {
+	mu      sync.Mutex
+	pending *backgroundCall
+}
+
+// backgroundCall is a call running in background
+type backgroundCall struct {
+	done chan struct{}
+	err  error
+}
+
+// call runs f in background and waits until it returned or ctx is done,
+// the call left running when ctx is done is reused by the next call,
+// so calling again doesn't block another goroutine
+func (c *pendingCall) call(ctx context.Context, f func() error) error {
+	c.mu.Lock()
+	p := c.pending
+	if p == nil {
+		p = &backgroundCall{done: make(chan struct{})}
+		c.pending = p
+
+		go func() {
+			p.err = f()
+
+			c.mu.Lock()
+			c.pending = nil
+			c.mu.Unlock()
+
+			close(p.done)
+		}()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return p.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isTimeout checks whether err is caused by deadline exceeded
+func isTimeout(err error) bool {
+	t, ok := err.(interface {
+		Timeout() bool
+	})
+	return ok && t.Timeout()
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestPendingCall(t *testing.T) {
+	var c pendingCall
+
+	started := make(chan struct{}, 2)
+	release := make(chan error)
+	f := func() error {
+		started <- struct{}{}
+		return <-release
+	}
+
+	// call left running when ctx is done is reused
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		if err := c.call(ctx, f); err != context.DeadlineExceeded {
+			t.Errorf("call should be interrupted by ctx: %v", err)
+		}
+		cancel()
+	}
+
+	<-started
+	select {
+	case <-started:
+		t.Errorf("call should be started once")
+	default:
+	}
+
+	release <- nil
+	for pending := true; pending; time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		pending = c.pending != nil
+		c.mu.Unlock()
+	}
+
+	// new call started after returned
+	done := make(chan error, 1)
+	go func() {
+		done <- c.call(context.Background(), f)
+	}()
+
+	<-started
+	release <- io.EOF
+	if err := <-done; err != io.EOF {
+		t.Errorf("call error not correct: %v", err)
+	}
+}
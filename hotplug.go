@@ -0,0 +1,207 @@
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// interval to look up serial ports if waiting for change is not supported
+const portPollInterval = time.Second
+
+// PortEventType is the type of PortEvent
+type PortEventType int
+
+const (
+	// PortAdded means the serial port is plugged in
+	PortAdded PortEventType = iota + 1
+	// PortRemoved means the serial port is removed
+	PortRemoved
+)
+
+func (t PortEventType) String() string {
+	switch t {
+	case PortAdded:
+		return "add"
+	case PortRemoved:
+		return "remove"
+	}
+	return "unknown"
+}
+
+// PortEvent is a serial port added to or removed from the system
+type PortEvent struct {
+	Type PortEventType
+	// Port info, it's the last known one for removed port
+	Port PortInfo
+	// Time when the event was detected
+	Time time.Time
+	// Err is set on the last event if watching stopped because of error
+	Err error
+}
+
+// portWaiter waits until serial ports may have been changed
+type portWaiter struct {
+	wait  func(ctx context.Context) error
+	close func()
+}
+
+// WatchPorts watches serial ports added to or removed from the system,
+// the returned channel is closed when ctx is done or watching failed
+//
+// on linux, kernel uevents are received via netlink, with inotify on /dev
+// and polling as fallback, not supported on other platforms
+func WatchPorts(ctx context.Context) <-chan PortEvent {
+	ch := make(chan PortEvent, 16)
+	go watchPorts(ctx, ch)
+	return ch
+}
+
+func watchPorts(ctx context.Context, ch chan<- PortEvent) {
+	defer close(ch)
+
+	emit := func(e PortEvent) bool {
+		select {
+		case ch <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	fail := func(err error) {
+		if ctx.Err() == nil {
+			emit(PortEvent{Time: time.Now(), Err: err})
+		}
+	}
+
+	waiter, err := newPortWaiter()
+	if err != nil {
+		fail(err)
+		return
+	}
+	defer waiter.close()
+
+	ports, err := ListPorts()
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	for {
+		if err = waiter.wait(ctx); err != nil {
+			fail(err)
+			return
+		}
+
+		newPorts, err := ListPorts()
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		now := time.Now()
+		for _, e := range diffPorts(ports, newPorts) {
+			e.Time = now
+			if !emit(e) {
+				return
+			}
+		}
+
+		ports = newPorts
+	}
+}
+
+// diffPorts returns events of ports removed from or added to old ports,
+// ports are compared by name and hardware identity, so that a port
+// replaced by another adapter with the same name is removed and added
+func diffPorts(oldPorts, newPorts []PortInfo) []PortEvent {
+	var (
+		events []PortEvent
+		oldSet = make(map[string]bool)
+		newSet = make(map[string]bool)
+	)
+
+	for _, p := range oldPorts {
+		oldSet[portIdentity(p)] = true
+	}
+
+	for _, p := range newPorts {
+		newSet[portIdentity(p)] = true
+	}
+
+	for _, p := range oldPorts {
+		if !newSet[portIdentity(p)] {
+			events = append(events, PortEvent{Type: PortRemoved, Port: p})
+		}
+	}
+
+	for _, p := range newPorts {
+		if !oldSet[portIdentity(p)] {
+			events = append(events, PortEvent{Type: PortAdded, Port: p})
+		}
+	}
+
+	return events
+}
+
+// portIdentity returns the key to compare ports
+func portIdentity(p PortInfo) string {
+	return fmt.Sprintf("%s|%s|%04x:%04x|%s|%s|%d", p.Name, p.Driver, p.VID, p.PID, p.SerialNumber, p.USBPath, p.Interface)
+}
+
+// WaitForPort blocks until the only serial port matches the selector appears,
+// MultiplePortsError is returned if more than one port matches
+func WaitForPort(ctx context.Context, sel PortSelector) (PortInfo, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// watch before finding to avoid missing events
+	watching := WatchPorts(ctx)
+	events := watching
+
+	// stop watching before return
+	defer func() {
+		cancel()
+		for range watching {
+		}
+	}()
+
+	// stable links (by-id) are created by udev after the port added,
+	// so look up periodically as well
+	ticker := time.NewTicker(portPollInterval)
+	defer ticker.Stop()
+
+	for {
+		p, err := FindPort(sel)
+		if err != ErrPortNotFound {
+			return p, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return PortInfo{}, ctx.Err()
+		case _, ok := <-events:
+			if !ok {
+				// keep polling if watching stopped
+				events = nil
+			}
+		case <-ticker.C:
+		}
+	}
+}
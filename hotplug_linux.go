@@ -0,0 +1,212 @@
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// directory watched by inotify for device nodes
+const devDir = "/dev"
+
+// portWaiters are tried in order, replaced in tests
+var portWaiters = []func() (*portWaiter, error){
+	newUeventPortWaiter,
+	newInotifyPortWaiter,
+	newPollPortWaiter,
+}
+
+// newPortWaiter returns the first available port waiter
+func newPortWaiter() (w *portWaiter, err error) {
+	for _, newWaiter := range portWaiters {
+		if w, err = newWaiter(); err == nil {
+			return w, nil
+		}
+	}
+	return nil, err
+}
+
+// newUeventPortWaiter receives kernel uevents via netlink,
+// and waits for events of tty subsystem
+func newUeventPortWaiter() (*portWaiter, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK,
+		unix.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, err
+	}
+
+	// group 1 is for kernel uevents
+	if err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: 1}); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	f, err := newPollableFile(fd, "uevent")
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 8192)
+	return &portWaiter{
+		wait: func(ctx context.Context) error {
+			return waitReadable(ctx, f, func(fd int) (bool, error) {
+				n, _, err := unix.Recvfrom(fd, buf, 0)
+				if err == unix.ENOBUFS {
+					// events dropped, treat as changed
+					return true, nil
+				} else if err != nil {
+					return false, err
+				}
+				return isTTYUevent(buf[:n]), nil
+			})
+		},
+		close: func() { f.Close() },
+	}, nil
+}
+
+// isTTYUevent checks whether the uevent message is about tty devices
+// the message is like "add@/devices/...\x00ACTION=add\x00SUBSYSTEM=tty\x00..."
+func isTTYUevent(msg []byte) bool {
+	for _, field := range bytes.Split(msg, []byte{0}) {
+		if bytes.Equal(field, []byte("SUBSYSTEM=tty")) {
+			return true
+		}
+	}
+	return false
+}
+
+// newInotifyPortWaiter watches device nodes created or deleted in /dev
+func newInotifyPortWaiter() (*portWaiter, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = unix.InotifyAddWatch(fd, devDir, unix.IN_CREATE|unix.IN_DELETE); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	f, err := newPollableFile(fd, "inotify")
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 4096)
+	return &portWaiter{
+		wait: func(ctx context.Context) error {
+			return waitReadable(ctx, f, func(fd int) (bool, error) {
+				_, err := unix.Read(fd, buf)
+				return err == nil, err
+			})
+		},
+		close: func() { f.Close() },
+	}, nil
+}
+
+// newPollPortWaiter waits for a poll interval
+func newPollPortWaiter() (*portWaiter, error) {
+	return &portWaiter{
+		wait: func(ctx context.Context) error {
+			timer := time.NewTimer(portPollInterval)
+			defer timer.Stop()
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+				return nil
+			}
+		},
+		close: func() {},
+	}, nil
+}
+
+// newPollableFile returns file of the non-blocking fd managed by runtime poller,
+// the fd is closed if it's not pollable
+func newPollableFile(fd int, name string) (*os.File, error) {
+	f := os.NewFile(uintptr(fd), name)
+	if err := f.SetReadDeadline(time.Time{}); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("fail to poll %s: %v", name, err)
+	}
+	return f, nil
+}
+
+// waitReadable waits until the file is readable and read returns true,
+// pending messages are all read before returning
+func waitReadable(ctx context.Context, f *os.File, read func(fd int) (bool, error)) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	// interrupt waiting by setting a deadline in the past when ctx is done
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		select {
+		case <-ctx.Done():
+			f.SetReadDeadline(aLongTimeAgo)
+		case <-done:
+		}
+	}()
+
+	defer func() {
+		close(done)
+		<-stopped
+		f.SetReadDeadline(time.Time{})
+	}()
+
+	var (
+		changed bool
+		readErr error
+	)
+
+	err = rc.Read(func(fd uintptr) bool {
+		for {
+			ok, err := read(int(fd))
+			if err == unix.EAGAIN {
+				break
+			} else if err != nil {
+				readErr = err
+				return true
+			}
+			changed = changed || ok
+		}
+
+		// wait for readable again if not changed
+		return changed
+	})
+
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	if err != nil {
+		return err
+	}
+	return readErr
+}
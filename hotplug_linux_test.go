@@ -0,0 +1,172 @@
+// +build linux
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestWatchPorts(t *testing.T) {
+	root, err := ioutil.TempDir("", "libserial-sysfs")
+	if err != nil {
+		t.Fatalf("create fixture root failed: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	makeSysfsFixture(t, root)
+
+	savedRoot, savedWaiters := sysfsRoot, portWaiters
+	sysfsRoot, portWaiters = root, []func() (*portWaiter, error){newPollPortWaiter}
+	defer func() {
+		sysfsRoot, portWaiters = savedRoot, savedWaiters
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	events := WatchPorts(ctx)
+
+	// stop watching before fixture removed
+	defer func() {
+		cancel()
+		for range events {
+		}
+	}()
+
+	found := make(chan PortInfo, 1)
+	go func() {
+		p, err := WaitForPort(ctx, PortSelector{VID: 0x1a86, PID: 0x7523})
+		if err != nil {
+			t.Errorf("wait for port failed: %v", err)
+		}
+		found <- p
+	}()
+
+	// wait for the initial scan
+	time.Sleep(100 * time.Millisecond)
+
+	// plug in a ch340 adapter
+	usbDir := filepath.Join(root, "devices/pci0000:00/usb1/1-4")
+	ttyDir := filepath.Join(usbDir, "1-4:1.0/ttyUSB2")
+	for _, dir := range []string{filepath.Join(ttyDir, "tty/ttyUSB2"), filepath.Join(root, "bus/usb-serial/drivers/ch341-uart")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("create fixture dir failed: %v", err)
+		}
+	}
+
+	for name, content := range map[string]string{
+		"devices/pci0000:00/usb1/1-4/idVendor":                 "1a86\n",
+		"devices/pci0000:00/usb1/1-4/idProduct":                "7523\n",
+		"devices/pci0000:00/usb1/1-4/1-4:1.0/bInterfaceNumber": "00\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatalf("create fixture attr failed: %v", err)
+		}
+	}
+
+	for link, target := range map[string]string{
+		filepath.Join(ttyDir, "tty/ttyUSB2/device"): ttyDir,
+		filepath.Join(ttyDir, "driver"):             filepath.Join(root, "bus/usb-serial/drivers/ch341-uart"),
+		filepath.Join(root, "class/tty/ttyUSB2"):    filepath.Join(ttyDir, "tty/ttyUSB2"),
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatalf("create fixture link failed: %v", err)
+		}
+	}
+
+	if e := <-events; e.Err != nil || e.Type != PortAdded || e.Port.Name != "ttyUSB2" {
+		t.Errorf("port added event not correct: %+v", e)
+	}
+
+	if p := <-found; p.Path != "/dev/ttyUSB2" || p.Driver != "ch341-uart" {
+		t.Errorf("found port not correct: %+v", p)
+	}
+
+	// unplug the adapter
+	if err := os.Remove(filepath.Join(root, "class/tty/ttyUSB2")); err != nil {
+		t.Fatalf("remove fixture link failed: %v", err)
+	}
+
+	if e := <-events; e.Err != nil || e.Type != PortRemoved || e.Port.Name != "ttyUSB2" {
+		t.Errorf("port removed event not correct: %+v", e)
+	}
+}
+
+func TestIsTTYUevent(t *testing.T) {
+	for msg, expected := range map[string]bool{
+		"add@/devices/pci0000:00/usb1/1-4/1-4:1.0/ttyUSB0/tty/ttyUSB0\x00ACTION=add\x00" +
+			"DEVPATH=/devices/pci0000:00/usb1/1-4/1-4:1.0/ttyUSB0/tty/ttyUSB0\x00SUBSYSTEM=tty\x00DEVNAME=ttyUSB0\x00": true,
+		"remove@/devices/pci0000:00/usb1/1-4\x00ACTION=remove\x00SUBSYSTEM=usb\x00DEVTYPE=usb_device\x00": false,
+		// subsystem in other fields should not match
+		"add@/devices/virtual/misc/x\x00ACTION=add\x00DEVPATH=SUBSYSTEM=tty\x00SUBSYSTEM=misc\x00": false,
+		"": false,
+	} {
+		if isTTYUevent([]byte(msg)) != expected {
+			t.Errorf("tty uevent check of %q should be %v", msg, expected)
+		}
+	}
+}
+
+func TestWaitReadable(t *testing.T) {
+	var fds [2]int
+	if err := unix.Pipe2(fds[:], unix.O_NONBLOCK|unix.O_CLOEXEC); err != nil {
+		t.Fatalf("create pipe failed: %v", err)
+	}
+	defer unix.Close(fds[1])
+
+	f, err := newPollableFile(fds[0], "pipe")
+	if err != nil {
+		t.Fatalf("pipe not pollable: %v", err)
+	}
+	defer f.Close()
+
+	// only 'y' counts as changed, pending bytes are all read
+	read := func(fd int) (bool, error) {
+		buf := make([]byte, 1)
+		_, err := unix.Read(fd, buf)
+		return err == nil && buf[0] == 'y', err
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		unix.Write(fds[1], []byte("nny"))
+	}()
+
+	if err := waitReadable(context.Background(), f, read); err != nil {
+		t.Errorf("wait readable failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	unix.Write(fds[1], []byte("n"))
+	start := time.Now()
+	if err := waitReadable(ctx, f, read); err != context.DeadlineExceeded {
+		t.Errorf("wait readable should be interrupted by ctx: %v", err)
+	}
+
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("wait readable interrupted too late: %v", d)
+	}
+}
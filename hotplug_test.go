@@ -0,0 +1,38 @@
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffPorts(t *testing.T) {
+	ftdi := PortInfo{Name: "ttyUSB0", Driver: "ftdi_sio", USB: true, VID: 0x0403, PID: 0x6001, SerialNumber: "A1"}
+	ch340 := PortInfo{Name: "ttyUSB0", Driver: "ch341-uart", USB: true, VID: 0x1a86, PID: 0x7523}
+	acm := PortInfo{Name: "ttyACM0", Driver: "cdc_acm"}
+
+	events := diffPorts([]PortInfo{ftdi, acm}, []PortInfo{acm, ch340})
+	expected := []PortEvent{{Type: PortRemoved, Port: ftdi}, {Type: PortAdded, Port: ch340}}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("events of replaced port not correct: %+v", events)
+	}
+
+	if events := diffPorts([]PortInfo{ftdi, acm}, []PortInfo{acm, ftdi}); len(events) != 0 {
+		t.Errorf("unexpected events of unchanged ports: %+v", events)
+	}
+}
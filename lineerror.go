@@ -0,0 +1,255 @@
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// LineErrorType is the type of LineError
+type LineErrorType int
+
+const (
+	// LineErrorParity is a byte received with parity error
+	LineErrorParity LineErrorType = iota + 1
+	// LineErrorFraming is a byte received with framing error
+	LineErrorFraming
+	// LineErrorBreak is a break condition received
+	LineErrorBreak
+	// LineErrorOverrun is input lost because of hardware or buffer overrun
+	LineErrorOverrun
+)
+
+func (t LineErrorType) String() string {
+	switch t {
+	case LineErrorParity:
+		return "parity"
+	case LineErrorFraming:
+		return "framing"
+	case LineErrorBreak:
+		return "break"
+	case LineErrorOverrun:
+		return "overrun"
+	}
+	return "unknown"
+}
+
+// LineError is an error detected in the input stream
+type LineError struct {
+	Type LineErrorType
+	// Offset of the error in decoded data, the count of data bytes before it
+	Offset int64
+	// Byte received with parity or framing error
+	Byte byte
+	// Count of errors, overrun can only be detected by counts
+	Count int
+}
+
+func (e LineError) Error() string {
+	switch e.Type {
+	case LineErrorParity, LineErrorFraming:
+		return fmt.Sprintf("%v error at offset %d: 0x%02x", e.Type, e.Offset, e.Byte)
+	}
+	return fmt.Sprintf("%v error at offset %d, count %d", e.Type, e.Offset, e.Count)
+}
+
+// lineErrorCounts are counts of line errors detected by the driver
+type lineErrorCounts struct {
+	parity, framing, brk, overrun uint32
+}
+
+// decoding states of marked input stream
+const (
+	markStateData = iota
+	markStateEscape
+	markStateError
+)
+
+// LineErrorReader decodes input stream marked by WithLineErrorMarking,
+// where byte with error X is read as \377 \0 X, break is read as \377 \0 \0,
+// and \377 is read as \377 \377, line errors are collected and
+// data bytes are returned by Read
+type LineErrorReader struct {
+	r io.Reader
+
+	// counts of line errors used to classify errors, nil if not supported
+	counts     func() (lineErrorCounts, error)
+	lastCounts lineErrorCounts
+
+	buf    []byte
+	state  int
+	offset int64
+
+	mu     sync.Mutex
+	errors []LineError
+}
+
+// NewLineErrorReader decodes marked input stream read from r, framing errors
+// can not be told from parity errors, and overrun can not be detected
+func NewLineErrorReader(r io.Reader) *LineErrorReader {
+	return &LineErrorReader{r: r}
+}
+
+// LineErrorReader decodes marked input stream read from serial port,
+// line errors are classified by error counts of the driver if supported
+func (s *SerialPort) LineErrorReader() *LineErrorReader {
+	d := NewLineErrorReader(s)
+
+	if counts, err := s.getLineErrorCounts(); err == nil {
+		d.counts = s.getLineErrorCounts
+		d.lastCounts = counts
+	}
+
+	return d
+}
+
+// Read decoded data bytes, line errors are collected and can be get by Errors
+func (d *LineErrorReader) Read(data []byte) (int, error) {
+	if len(d.buf) < len(data) {
+		d.buf = make([]byte, len(data))
+	}
+
+	for {
+		size := len(data)
+		if d.state == markStateEscape {
+			// pending \377 may be decoded with next byte as two data bytes
+			if size < 2 {
+				return 0, io.ErrShortBuffer
+			}
+			size--
+		}
+
+		n, err := d.r.Read(d.buf[:size])
+
+		var delta lineErrorCounts
+		if d.counts != nil && (n > 0 || err == nil) {
+			delta = d.countsDelta()
+		}
+
+		m := d.decode(data, d.buf[:n], &delta)
+
+		// overrun can only be detected by counts
+		if delta.overrun > 0 {
+			d.addError(LineError{Type: LineErrorOverrun, Offset: d.offset, Count: int(delta.overrun)})
+		}
+
+		// input is all markers
+		if m == 0 && n > 0 && err == nil {
+			continue
+		}
+
+		return m, err
+	}
+}
+
+// Errors returns line errors collected since last call
+func (d *LineErrorReader) Errors() []LineError {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	errs := d.errors
+	d.errors = nil
+	return errs
+}
+
+// decode marked input into data, returns count of data bytes
+func (d *LineErrorReader) decode(data, input []byte, delta *lineErrorCounts) int {
+	n := 0
+	for _, b := range input {
+		switch d.state {
+		case markStateData:
+			if b == 0xff {
+				d.state = markStateEscape
+				continue
+			}
+			data[n] = b
+			n++
+			d.offset++
+		case markStateEscape:
+			switch b {
+			case 0xff:
+				data[n] = b
+				n++
+				d.offset++
+				d.state = markStateData
+			case 0x00:
+				d.state = markStateError
+			default:
+				// not a valid mark, keep both bytes
+				data[n], data[n+1] = 0xff, b
+				n += 2
+				d.offset += 2
+				d.state = markStateData
+			}
+		case markStateError:
+			d.addError(classifyLineError(b, d.offset, delta))
+			d.state = markStateData
+		}
+	}
+
+	return n
+}
+
+// countsDelta returns counts of line errors since last call
+func (d *LineErrorReader) countsDelta() lineErrorCounts {
+	counts, err := d.counts()
+	if err != nil {
+		return lineErrorCounts{}
+	}
+
+	last := d.lastCounts
+	d.lastCounts = counts
+
+	return lineErrorCounts{
+		parity:  counts.parity - last.parity,
+		framing: counts.framing - last.framing,
+		brk:     counts.brk - last.brk,
+		overrun: counts.overrun - last.overrun,
+	}
+}
+
+// classifyLineError classifies the marked byte with counts of line errors,
+// \377 \0 \0 is break without counts, it can also be \0 with parity error
+func classifyLineError(b byte, offset int64, delta *lineErrorCounts) LineError {
+	e := LineError{Offset: offset, Byte: b, Count: 1}
+
+	switch {
+	case b == 0 && delta.brk > 0:
+		delta.brk--
+		e.Type = LineErrorBreak
+	case delta.framing > 0:
+		delta.framing--
+		e.Type = LineErrorFraming
+	case delta.parity > 0:
+		delta.parity--
+		e.Type = LineErrorParity
+	case b == 0:
+		e.Type = LineErrorBreak
+	default:
+		e.Type = LineErrorParity
+	}
+
+	return e
+}
+
+func (d *LineErrorReader) addError(e LineError) {
+	d.mu.Lock()
+	d.errors = append(d.errors, e)
+	d.mu.Unlock()
+}
@@ -0,0 +1,117 @@
+// +build !windows
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFilePath returns UUCP lock file path of the device in dir,
+// symlinks (e.g. /dev/serial/by-id/...) are resolved to share the lock
+// with the device they point to
+func lockFilePath(dir, dev string) string {
+	if dir == "" {
+		dir = DefaultLockDir
+	}
+
+	if resolved, err := filepath.EvalSymlinks(dev); err == nil {
+		dev = resolved
+	}
+	return filepath.Join(dir, "LCK.."+filepath.Base(dev))
+}
+
+// createLockFile creates UUCP lock file with current PID (HDB format),
+// lock file of a dead process is removed before creating,
+// ErrPortBusy is returned if the lock is held by a living process
+func createLockFile(path string) error {
+	// try again after stale lock file removed
+	for i := 0; i < 2; i++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%10d\n", os.Getpid())
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+
+			if err != nil {
+				os.Remove(path)
+				return fmt.Errorf("fail to write lock file: %v", err)
+			}
+			return nil
+		}
+
+		if !os.IsExist(err) {
+			return fmt.Errorf("fail to create lock file: %v", err)
+		}
+
+		// the lock file may be being written, or in unknown format
+		pid, err := readLockFile(path)
+		if err != nil || processExists(pid) {
+			return ErrPortBusy
+		}
+
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("fail to remove stale lock file: %v", err)
+		}
+	}
+
+	return ErrPortBusy
+}
+
+// removeLockFile removes the lock file if it's owned by current process
+func removeLockFile(path string) error {
+	pid, err := readLockFile(path)
+	if err != nil {
+		return err
+	}
+
+	if pid != os.Getpid() {
+		return fmt.Errorf("lock file is not owned by current process: %v", pid)
+	}
+
+	return os.Remove(path)
+}
+
+// readLockFile returns PID in the lock file
+func readLockFile(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid lock file content: %q", data)
+	}
+
+	return pid, nil
+}
+
+// processExists checks whether the process is still running
+func processExists(pid int) bool {
+	err := unix.Kill(pid, 0)
+	return err == nil || err == unix.EPERM
+}
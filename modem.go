@@ -0,0 +1,175 @@
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"context"
+	"time"
+)
+
+// interval to poll modem status lines if waiting for change is not supported
+const modemPollInterval = 10 * time.Millisecond
+
+// modem status lines can be watched
+var modemStatusLines = []ModemLine{ModemLineCTS, ModemLineDSR, ModemLineDCD, ModemLineRI}
+
+// ModemLine is a bit set of modem control/status lines
+// available values are {ModemLineDTR, ModemLineRTS, ModemLineCTS,
+// ModemLineDSR, ModemLineDCD, ModemLineRI}
+type ModemLine uint32
+
+// ModemEvent is a transition of modem status line
+type ModemEvent struct {
+	// Line changed
+	Line ModemLine
+	// State of the line after transition
+	State bool
+	// Edges is the count of transitions since last event of the line,
+	// it can be more than 1 if there were short pulses
+	Edges uint32
+	// Time when the transition was detected
+	Time time.Time
+	// Err is set on the last event if watching stopped because of error
+	Err error
+}
+
+// SetDTR set (on) or clear (off) the DTR (Data Terminal Ready) line
+func (s *SerialPort) SetDTR(on bool) error {
+	return s.setModemLines(ModemLineDTR, on)
+}
+
+// SetRTS set (on) or clear (off) the RTS (Request To Send) line
+func (s *SerialPort) SetRTS(on bool) error {
+	return s.setModemLines(ModemLineRTS, on)
+}
+
+// ModemStatus returns the modem lines which are currently set
+// DTR and RTS are not reported on windows
+func (s *SerialPort) ModemStatus() (ModemLine, error) {
+	return s.getModemLines()
+}
+
+// WatchModemLines watches transitions of modem status lines (CTS, DSR, DCD and RI)
+// selected by mask, the returned channel is closed when ctx is done or watching failed
+//
+// on linux, TIOCMIWAIT is used to wait for transitions and TIOCGICOUNT to count edges,
+// so short pulses are reported with Edges, on other platforms (or drivers not
+// supporting them) the lines are polled instead
+func (s *SerialPort) WatchModemLines(ctx context.Context, mask ModemLine) <-chan ModemEvent {
+	ch := make(chan ModemEvent, len(modemStatusLines))
+	go watchModemLines(ctx, mask&(ModemLineCTS|ModemLineDSR|ModemLineDCD|ModemLineRI), s.modemState, s.waitModemChange, ch)
+	return ch
+}
+
+// modemStateFunc returns modem lines which are set, and transition counts
+// of modem status lines, counts are nil if not supported
+type modemStateFunc func() (ModemLine, map[ModemLine]uint32, error)
+
+// modemWaitFunc waits until any of the modem status lines changed,
+// ErrNotSupported is returned if waiting is not supported
+type modemWaitFunc func(ctx context.Context) error
+
+func watchModemLines(ctx context.Context, mask ModemLine, state modemStateFunc, wait modemWaitFunc, ch chan<- ModemEvent) {
+	defer close(ch)
+
+	emit := func(e ModemEvent) bool {
+		select {
+		case ch <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	fail := func(err error) {
+		if ctx.Err() == nil {
+			emit(ModemEvent{Time: time.Now(), Err: err})
+		}
+	}
+
+	status, counts, err := state()
+	if err != nil {
+		fail(err)
+		return
+	}
+
+	// poll the lines if waiting is not supported
+	var poll <-chan time.Time
+	for {
+		if poll == nil {
+			err = wait(ctx)
+			if err == ErrNotSupported {
+				ticker := time.NewTicker(modemPollInterval)
+				defer ticker.Stop()
+				poll, err = ticker.C, nil
+			}
+		} else {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+			case <-poll:
+			}
+		}
+
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		newStatus, newCounts, err := state()
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		for _, e := range diffModemState(mask, status, newStatus, counts, newCounts) {
+			if !emit(e) {
+				return
+			}
+		}
+
+		status, counts = newStatus, newCounts
+	}
+}
+
+// diffModemState returns transitions of modem status lines in mask
+func diffModemState(mask, status, newStatus ModemLine, counts, newCounts map[ModemLine]uint32) []ModemEvent {
+	var (
+		now    = time.Now()
+		events []ModemEvent
+	)
+
+	for _, line := range modemStatusLines {
+		if mask&line == 0 {
+			continue
+		}
+
+		edges := newCounts[line] - counts[line]
+		if edges == 0 && (status^newStatus)&line != 0 {
+			// counts not supported or not updated yet
+			edges = 1
+		}
+
+		if edges == 0 {
+			continue
+		}
+
+		events = append(events, ModemEvent{Line: line, State: newStatus&line != 0, Edges: edges, Time: now})
+	}
+
+	return events
+}
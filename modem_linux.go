@@ -0,0 +1,124 @@
+// +build linux
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"context"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// serialICounter is struct serial_icounter_struct in linux/serial.h
+type serialICounter struct {
+	CTS, DSR, RNG, DCD int32
+	RX, TX             int32
+	Frame, Overrun     int32
+	Parity, BRK        int32
+	BufOverrun         int32
+	reserved           [9]int32
+}
+
+// getICounter returns interrupt counters of the serial port
+func (s *SerialPort) getICounter() (*serialICounter, error) {
+	return getICounter(s.fdIoctl)
+}
+
+// getICounter returns interrupt counters (TIOCGICOUNT)
+func getICounter(do ioctlFunc) (*serialICounter, error) {
+	c := &serialICounter{}
+	if err := do(unix.TIOCGICOUNT, unsafe.Pointer(c)); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// modemState returns modem lines and transition counts of modem status lines
+func (s *SerialPort) modemState() (ModemLine, map[ModemLine]uint32, error) {
+	return getModemState(s.fdIoctl)
+}
+
+// waitModemChange waits until any of the modem status lines changed (TIOCMIWAIT),
+// the ioctl returns on next transition even if ctx is done, it's reused
+// by the next wait of the serial port
+func (s *SerialPort) waitModemChange(ctx context.Context) error {
+	err := s.modemWait.call(ctx, func() error {
+		return ioctl(s.f.Fd(), unix.TIOCMIWAIT, uintptr(ModemLineCTS|ModemLineDSR|ModemLineDCD|ModemLineRI))
+	})
+
+	if err == unix.EINVAL || err == unix.ENOTTY {
+		// driver doesn't support TIOCMIWAIT (e.g. pty)
+		return ErrNotSupported
+	}
+	return err
+}
+
+// getModemState returns modem lines (TIOCMGET) and transition counts of
+// modem status lines (TIOCGICOUNT), counts are nil if not supported
+func getModemState(do ioctlFunc) (ModemLine, map[ModemLine]uint32, error) {
+	lines, err := getModemLines(do)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	c, err := getICounter(do)
+	if err != nil {
+		return lines, nil, nil
+	}
+
+	return lines, map[ModemLine]uint32{
+		ModemLineCTS: uint32(c.CTS),
+		ModemLineDSR: uint32(c.DSR),
+		ModemLineDCD: uint32(c.DCD),
+		ModemLineRI:  uint32(c.RNG),
+	}, nil
+}
+
+// getLineErrorCounts returns counts of line errors detected by the driver
+func (s *SerialPort) getLineErrorCounts() (lineErrorCounts, error) {
+	c, err := s.getICounter()
+	if err != nil {
+		return lineErrorCounts{}, err
+	}
+
+	return lineErrorCounts{
+		parity:  uint32(c.Parity),
+		framing: uint32(c.Frame),
+		brk:     uint32(c.BRK),
+		overrun: uint32(c.Overrun) + uint32(c.BufOverrun),
+	}, nil
+}
+
+// getDriverCounters fills counters of the driver into c,
+// c is left unchanged if the driver doesn't support TIOCGICOUNT
+func (s *SerialPort) getDriverCounters(c *Counters) error {
+	ic, err := s.getICounter()
+	if err == unix.EINVAL || err == unix.ENOTTY {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("fail to get serial port counters: %v", err)
+	}
+
+	c.Driver = true
+	c.RX, c.TX = uint32(ic.RX), uint32(ic.TX)
+	c.Frame, c.Parity, c.Break = uint32(ic.Frame), uint32(ic.Parity), uint32(ic.BRK)
+	c.Overrun, c.BufOverrun = uint32(ic.Overrun), uint32(ic.BufOverrun)
+	return nil
+}
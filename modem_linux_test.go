@@ -0,0 +1,204 @@
+// +build linux
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"context"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// fakeModem is a fake driver of modem lines and interrupt counters
+type fakeModem struct {
+	*fakeDriver
+	lines   ModemLine
+	counter serialICounter
+	changed chan struct{}
+}
+
+// newFakeModem creates fakeModem with lines set, interrupt counters are
+// not supported if counts is false
+func newFakeModem(lines ModemLine, counts bool) *fakeModem {
+	m := &fakeModem{fakeDriver: newFakeDriver(), lines: lines, changed: make(chan struct{}, 1)}
+
+	m.handle(unix.TIOCMGET, func(arg unsafe.Pointer) error {
+		*(*int32)(arg) = int32(m.lines)
+		return nil
+	})
+	m.handle(unix.TIOCMBIS, func(arg unsafe.Pointer) error {
+		m.lines |= ModemLine(*(*int32)(arg))
+		return nil
+	})
+	m.handle(unix.TIOCMBIC, func(arg unsafe.Pointer) error {
+		m.lines &^= ModemLine(*(*int32)(arg))
+		return nil
+	})
+
+	if counts {
+		m.handle(unix.TIOCGICOUNT, func(arg unsafe.Pointer) error {
+			*(*serialICounter)(arg) = m.counter
+			return nil
+		})
+	}
+
+	return m
+}
+
+// change updates lines or counters with f and wakes the waiting watcher
+func (m *fakeModem) change(f func()) {
+	m.locked(f)
+
+	select {
+	case m.changed <- struct{}{}:
+	default:
+	}
+}
+
+// wait waits for change like TIOCMIWAIT
+func (m *fakeModem) wait(ctx context.Context) error {
+	select {
+	case <-m.changed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestModemLines(t *testing.T) {
+	m := newFakeModem(ModemLine(unix.TIOCM_CTS|unix.TIOCM_CAR|unix.TIOCM_RNG), true)
+
+	lines, err := getModemLines(m.ioctl)
+	if err != nil {
+		t.Fatalf("get modem lines failed: %v", err)
+	}
+	if lines != ModemLineCTS|ModemLineDCD|ModemLineRI {
+		t.Errorf("modem lines not correct: %#x", lines)
+	}
+
+	if err = setModemLines(m.ioctl, ModemLineDTR|ModemLineRTS, true); err != nil {
+		t.Fatalf("set modem lines failed: %v", err)
+	}
+	if m.lines != ModemLine(unix.TIOCM_CTS|unix.TIOCM_CAR|unix.TIOCM_RNG|unix.TIOCM_DTR|unix.TIOCM_RTS) {
+		t.Errorf("modem lines not set: %#x", m.lines)
+	}
+
+	if err = setModemLines(m.ioctl, ModemLineRTS, false); err != nil {
+		t.Fatalf("clear modem lines failed: %v", err)
+	}
+	if m.lines != ModemLine(unix.TIOCM_CTS|unix.TIOCM_CAR|unix.TIOCM_RNG|unix.TIOCM_DTR) {
+		t.Errorf("modem lines not cleared: %#x", m.lines)
+	}
+
+	lines, err = getModemLines(m.ioctl)
+	if err != nil {
+		t.Fatalf("get modem lines failed: %v", err)
+	}
+	if lines != ModemLineDTR|ModemLineCTS|ModemLineDCD|ModemLineRI {
+		t.Errorf("modem lines not correct: %#x", lines)
+	}
+}
+
+func TestWatchModemLines(t *testing.T) {
+	for _, c := range []struct {
+		counts, waits bool
+	}{{true, true}, {false, true}, {true, false}, {false, false}} {
+		m := newFakeModem(ModemLineCTS, c.counts)
+
+		// lines are polled if waiting is not supported
+		wait := m.wait
+		if !c.waits {
+			wait = func(context.Context) error { return ErrNotSupported }
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ch := make(chan ModemEvent)
+		go watchModemLines(ctx, ModemLineCTS|ModemLineDSR, func() (ModemLine, map[ModemLine]uint32, error) {
+			return getModemState(m.ioctl)
+		}, wait, ch)
+
+		// wait for the initial state read
+		for len(m.requests()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		// DSR raised
+		m.change(func() {
+			m.lines |= ModemLineDSR
+			m.counter.DSR++
+		})
+		if e := <-ch; e.Line != ModemLineDSR || !e.State || e.Edges != 1 {
+			t.Errorf("DSR event not correct: %+v", e)
+		}
+
+		// short pulse on CTS, only detected by counts
+		m.change(func() {
+			m.counter.CTS += 2
+			m.lines |= ModemLineRI
+		})
+		if c.counts {
+			if e := <-ch; e.Line != ModemLineCTS || !e.State || e.Edges != 2 {
+				t.Errorf("CTS event not correct: %+v", e)
+			}
+		}
+
+		// RI is not in mask
+		m.change(func() {
+			m.lines &^= ModemLineCTS
+			m.counter.CTS++
+		})
+		if e := <-ch; e.Line != ModemLineCTS || e.State || e.Edges != 1 {
+			t.Errorf("CTS event not correct: %+v", e)
+		}
+
+		// channel closed without leaking when ctx canceled
+		cancel()
+		for e := range ch {
+			t.Errorf("unexpected event after canceled: %+v", e)
+		}
+	}
+}
+
+func TestSerialPort_WatchModemLines(t *testing.T) {
+	r, w := getSerialPort(t, baseOptions)
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	// pty doesn't support TIOCMIWAIT, the lines are polled instead
+	if err := r.waitModemChange(context.Background()); err != ErrNotSupported {
+		t.Errorf("wait modem change on pty should fail with ErrNotSupported: %v", err)
+	}
+
+	// polling fails since pty doesn't support modem lines either
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ch := r.WatchModemLines(ctx, ModemLineCTS|ModemLineDSR|ModemLineDCD|ModemLineRI)
+	if e := <-ch; e.Err == nil {
+		t.Errorf("watching pty should fail: %+v", e)
+	}
+
+	if e, ok := <-ch; ok {
+		t.Errorf("unexpected event after failed: %+v", e)
+	}
+}
@@ -0,0 +1,42 @@
+// +build !linux
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import "context"
+
+// modemState returns modem lines, transition counts are not supported
+func (s *SerialPort) modemState() (ModemLine, map[ModemLine]uint32, error) {
+	lines, err := s.getModemLines()
+	return lines, nil, err
+}
+
+// waitModemChange is not supported, the lines are polled instead
+func (s *SerialPort) waitModemChange(ctx context.Context) error {
+	return ErrNotSupported
+}
+
+// getLineErrorCounts returns counts of line errors detected by the driver
+func (s *SerialPort) getLineErrorCounts() (lineErrorCounts, error) {
+	return lineErrorCounts{}, ErrNotSupported
+}
+
+// getDriverCounters leaves c unchanged, driver counters are linux only
+func (s *SerialPort) getDriverCounters(c *Counters) error {
+	return nil
+}
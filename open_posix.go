@@ -20,8 +20,11 @@ package libserial
 
 import (
 	"fmt"
+	"io"
 	"math"
 	"os"
+	"time"
+	"unsafe"
 
 	"golang.org/x/sys/unix"
 )
@@ -32,65 +35,620 @@ func (s *SerialPort) open() error {
 		err error
 	)
 
+	if s.lockFile {
+		lockPath := lockFilePath(s.lockDir, s.dev)
+		if err = createLockFile(lockPath); err != nil {
+			return err
+		}
+
+		defer func() {
+			if err != nil {
+				removeLockFile(lockPath)
+			} else {
+				s.lockPath = lockPath
+			}
+		}()
+	}
+
 	f, err = os.OpenFile(s.dev, serialFileFlag, 0666)
 	if err != nil {
+		// opened by another process with TIOCEXCL set
+		if pathErr, ok := err.(*os.PathError); ok && pathErr.Err == unix.EBUSY {
+			return ErrPortBusy
+		}
 		return err
 	}
 
 	defer func() {
 		if err != nil {
 			f.Close()
-		} else {
-			s.f = f
+			s.f = nil
 		}
 	}()
 
-	// get posix timeout value (seconds / 10)
-	timeout := int64(0)
-	if s.readTimeout > 0 {
-		timeout = s.readTimeout.Nanoseconds() / 1e8
-		if timeout > math.MaxUint8 {
-			timeout = math.MaxUint8
+	s.f = f
+
+	// O_NONBLOCK is used to avoid waiting for carrier when opening, and makes
+	// the file managed by runtime poller, so that deadlines can be supported,
+	// otherwise clear it and let read timeout (VTIME) handled by the kernel
+	s.pollable = f.SetDeadline(time.Time{}) == nil
+	if !s.pollable {
+		err = unix.SetNonblock(int(f.Fd()), false)
+		if err != nil {
+			return err
 		}
 	}
 
+	if err = s.checkPollable(); err != nil {
+		return err
+	}
+
+	if s.exclusive {
+		err = s.lock()
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.inheritSettings {
+		err = s.inherit()
+	} else {
+		err = s.configure()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.flush = mkFlushFunc(f.Fd())
+	s.drain = mkDrainFunc(f.Fd())
+
+	return nil
+}
+
+// lock takes an advisory lock and sets exclusive mode (TIOCEXCL),
+// ErrPortBusy is returned if the lock is held by another one
+func (s *SerialPort) lock() error {
+	fd := int(s.f.Fd())
+
+	err := unix.Flock(fd, unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		return ErrPortBusy
+	} else if err != nil {
+		return fmt.Errorf("fail to lock serial port: %v", err)
+	}
+
+	return ioctl(s.f.Fd(), unix.TIOCEXCL, 0)
+}
+
+// close serial port and clear exclusive mode (the advisory lock
+// is released along with the file), then remove the lock file
+func (s *SerialPort) close() error {
+	if s.exclusive {
+		ioctl(s.f.Fd(), unix.TIOCNXCL, 0)
+	}
+
+	err := s.f.Close()
+
+	// remove the lock file even if failed to close
+	if s.lockPath != "" {
+		if lockErr := removeLockFile(s.lockPath); err == nil {
+			err = lockErr
+		}
+		s.lockPath = ""
+	}
+
+	return err
+}
+
+// configure applies current options to the opened serial port
+func (s *SerialPort) configure() error {
+	if err := s.checkPollable(); err != nil {
+		return err
+	}
+
+	fd := int(s.f.Fd())
+
 	// check sys baud rate when baud rate not present
-	if s.baudRate == unix.B0 {
-		tty, err := unix.IoctlGetTermios(int(f.Fd()), termiosReqGet)
+	if s.baudRate == 0 {
+		tty, err := unix.IoctlGetTermios(fd, termiosReqGet)
 		if err != nil {
 			return fmt.Errorf("fail to get serial port config: %v", err)
 		}
 
-		s.baudRate = uint64(tty.Cflag) & maskBaudRate
-		if s.baudRate == unix.B0 {
-			s.baudRate = uint64(tty.Ispeed)
+		inputBaudRate, outputBaudRate := getTermiosSpeed(tty)
+		if outputBaudRate == 0 {
+			return fmt.Errorf("fail to determine serial port baud rate")
 		}
 
-		if s.baudRate == unix.B0 {
-			return fmt.Errorf("fail to determine serial port baud rate: %v", err)
+		s.baudRate = outputBaudRate
+		if s.inputBaudRate == 0 {
+			s.inputBaudRate = inputBaudRate
 		}
 	}
 
+	// use output baud rate if input baud rate not present
+	if s.inputBaudRate == 0 {
+		s.inputBaudRate = s.baudRate
+	}
+
 	tty := &unix.Termios{
-		Cflag:  unix.CREAD | unix.CLOCAL | termiosFlagType(s.controlOptions),
-		Iflag:  termiosFlagType(s.inputOptions),
-		Ispeed: termiosSpeedType(s.baudRate),
-		Ospeed: termiosSpeedType(s.baudRate),
+		Cflag: unix.CREAD | unix.CLOCAL | termiosFlagType(s.controlOptions),
+		Iflag: termiosFlagType(s.inputOptions),
 	}
+	setTermiosSpeed(tty, s.inputBaudRate, s.baudRate)
+
+	// set read policy
+	tty.Cc[unix.VMIN], tty.Cc[unix.VTIME] = s.readPolicy()
 
-	if timeout == 0 {
-		// set blocking read with at least 1 byte have read if no timeout defined
-		tty.Cc[unix.VMIN] = 1
+	setTranslation(tty, s.inputTranslation, s.outputTranslation)
+
+	if s.canonical != nil {
+		setCanonicalMode(tty, s.canonical)
+	}
+
+	// choose when the change takes effect
+	req := termiosReqSet
+	switch s.applyWhen {
+	case ApplyAfterDrain:
+		req = termiosReqSetDrain
+	case ApplyAfterFlush:
+		req = termiosReqSetFlush
 	}
-	// set read timeout
-	tty.Cc[unix.VTIME] = uint8(timeout)
 
-	err = unix.IoctlSetTermios(int(f.Fd()), termiosReqSet, tty)
+	// re-applied if the rest of config failed, so that the device keeps
+	// the config before as options are rolled back
+	saved, err := unix.IoctlGetTermios(fd, termiosReqGet)
 	if err != nil {
+		return fmt.Errorf("fail to get serial port config: %v", err)
+	}
+
+	if err := unix.IoctlSetTermios(fd, req, tty); err != nil {
 		return err
 	}
 
-	s.flush = mkFlushFunc(f.Fd())
+	restoreRS485, err := s.configureRS485()
+	if err != nil {
+		unix.IoctlSetTermios(fd, termiosReqSet, saved)
+		return err
+	}
+
+	if err := s.configureSerialInfo(); err != nil {
+		restoreRS485()
+		unix.IoctlSetTermios(fd, termiosReqSet, saved)
+		return err
+	}
+
+	return nil
+}
+
+// termios flags of input and output translations
+var (
+	inputTranslationFlags = map[InputTranslation]uint64{
+		InputCRToNL:         unix.ICRNL,
+		InputNLToCR:         unix.INLCR,
+		InputIgnoreCR:       unix.IGNCR,
+		InputStrip:          unix.ISTRIP,
+		InputIgnoreBreak:    unix.IGNBRK,
+		InputBreakInterrupt: unix.BRKINT,
+	}
+	outputTranslationFlags = map[OutputTranslation]uint64{
+		OutputNLToCRNL: unix.ONLCR,
+		OutputCRToNL:   unix.OCRNL,
+	}
+)
+
+// setTranslation set termios flags of input and output translations
+func setTranslation(tty *unix.Termios, in InputTranslation, out OutputTranslation) {
+	for t, flag := range inputTranslationFlags {
+		if in&t != 0 {
+			tty.Iflag |= termiosFlagType(flag)
+		}
+	}
+
+	for t, flag := range outputTranslationFlags {
+		if out&t != 0 {
+			// output processing is required for translations
+			tty.Oflag |= termiosFlagType(unix.OPOST | flag)
+		}
+	}
+}
+
+// getTranslation returns input and output translations set in termios
+func getTranslation(tty *unix.Termios) (in InputTranslation, out OutputTranslation) {
+	for t, flag := range inputTranslationFlags {
+		if uint64(tty.Iflag)&flag != 0 {
+			in |= t
+		}
+	}
+
+	if tty.Oflag&unix.OPOST != 0 {
+		for t, flag := range outputTranslationFlags {
+			if uint64(tty.Oflag)&flag != 0 {
+				out |= t
+			}
+		}
+	}
 
+	return in, out
+}
+
+// setCanonicalMode enable canonical mode with special characters
+func setCanonicalMode(tty *unix.Termios, c *CanonicalConfig) {
+	tty.Lflag |= unix.ICANON
+	if c.Echo {
+		tty.Lflag |= unix.ECHO | unix.ECHOE | unix.ECHOK
+	}
+
+	for i, ch := range map[int]byte{
+		unix.VEOF:   c.EOF,
+		unix.VEOL:   c.EOL,
+		unix.VEOL2:  c.EOL2,
+		unix.VERASE: c.Erase,
+		unix.VKILL:  c.Kill,
+	} {
+		if ch == 0 {
+			ch = posixVDisable
+		}
+		tty.Cc[i] = ch
+	}
+}
+
+// getCanonicalMode returns canonical mode config, nil in raw mode
+func getCanonicalMode(tty *unix.Termios) *CanonicalConfig {
+	if tty.Lflag&unix.ICANON == 0 {
+		return nil
+	}
+
+	char := func(i int) byte {
+		if tty.Cc[i] == posixVDisable {
+			return 0
+		}
+		return tty.Cc[i]
+	}
+
+	return &CanonicalConfig{
+		EOF:   char(unix.VEOF),
+		EOL:   char(unix.VEOL),
+		EOL2:  char(unix.VEOL2),
+		Erase: char(unix.VERASE),
+		Kill:  char(unix.VKILL),
+		Echo:  tty.Lflag&unix.ECHO != 0,
+	}
+}
+
+// checkPollable checks options requiring the file managed by runtime poller,
+// write timeout can not be emulated when the write is blocking
+func (s *SerialPort) checkPollable() error {
+	if !s.pollable && s.writeTimeout > 0 {
+		return ErrNotSupported
+	}
+	return nil
+}
+
+// readPolicy returns VMIN and VTIME for current read options
+func (o *portOptions) readPolicy() (vmin, vtime uint8) {
+	if o.minReadBytes == 0 && o.interCharTimeout == 0 {
+		// get posix timeout value (seconds / 10)
+		timeout := int64(0)
+		if o.readTimeout > 0 {
+			timeout = o.readTimeout.Nanoseconds() / 1e8
+			if timeout > math.MaxUint8 {
+				timeout = math.MaxUint8
+			}
+		}
+
+		if timeout == 0 {
+			// set blocking read with at least 1 byte have read if no timeout defined
+			return 1, 0
+		}
+		return 0, uint8(timeout)
+	}
+
+	// read until buffer filled or gap exceeded if min read bytes not set
+	vmin = math.MaxUint8
+	if o.minReadBytes > 0 {
+		vmin = uint8(o.minReadBytes)
+	}
+
+	if o.interCharTimeout > 0 {
+		// round up, or inter-character timer will be disabled
+		timeout := (o.interCharTimeout.Nanoseconds() + 1e8 - 1) / 1e8
+		if timeout > math.MaxUint8 {
+			timeout = math.MaxUint8
+		}
+		vtime = uint8(timeout)
+	}
+
+	return vmin, vtime
+}
+
+// read bytes from serial port, read timeout is emulated with read deadline
+// when the file is managed by runtime poller
+func (s *SerialPort) read(data []byte) (int, error) {
+	opts := s.options()
+
+	s.deadlineMu.Lock()
+	deadline := s.readDeadline
+	useTimeout := deadline.IsZero() && opts.readTimeout > 0
+	if useTimeout {
+		deadline = time.Now().Add(opts.readTimeout)
+	}
+
+	if s.pollable {
+		s.f.SetReadDeadline(deadline)
+	}
+	s.deadlineMu.Unlock()
+
+	n, err := s.f.Read(data)
+	// lines are returned as a whole in canonical mode
+	if err == nil && s.pollable && opts.canonical == nil {
+		n, err = s.readMore(data, n, deadline, &opts)
+	}
+
+	if isTimeout(err) {
+		if useTimeout {
+			return n, errReadTimeout
+		}
+		return n, ErrTimeout
+	}
+
+	// nothing read before VTIME exceeded, file not pollable is blocking
+	if err == io.EOF && n == 0 && useTimeout && !s.pollable && opts.canonical == nil {
+		if vmin, vtime := opts.readPolicy(); vmin == 0 && vtime > 0 {
+			return 0, errReadTimeout
+		}
+	}
+
+	return n, err
+}
+
+// readMore keeps reading until min read bytes reached or inter-character
+// timeout exceeded, since VMIN and VTIME are ignored for non-blocking file
+func (s *SerialPort) readMore(data []byte, n int, deadline time.Time, opts *portOptions) (int, error) {
+	vmin, _ := opts.readPolicy()
+
+	min := int(vmin)
+	if min > len(data) {
+		min = len(data)
+	}
+
+	for n < min {
+		s.deadlineMu.Lock()
+		d := deadline
+		if !s.readDeadline.IsZero() {
+			d = s.readDeadline
+		}
+
+		if opts.interCharTimeout > 0 {
+			gap := time.Now().Add(opts.interCharTimeout)
+			if d.IsZero() || gap.Before(d) {
+				d = gap
+			}
+		}
+		s.f.SetReadDeadline(d)
+		s.deadlineMu.Unlock()
+
+		m, err := s.f.Read(data[n:])
+		n += m
+		if err != nil {
+			// return bytes already read, timeout will be reported by next read
+			if isTimeout(err) {
+				return n, nil
+			}
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// write bytes to serial port, write timeout is emulated with write deadline
+// when the file is managed by runtime poller
+func (s *SerialPort) write(data []byte) (int, error) {
+	opts := s.options()
+
+	s.deadlineMu.Lock()
+	deadline := s.writeDeadline
+	if deadline.IsZero() && opts.writeTimeout > 0 {
+		deadline = time.Now().Add(opts.writeTimeout)
+	}
+
+	if s.pollable {
+		s.f.SetWriteDeadline(deadline)
+	}
+	s.deadlineMu.Unlock()
+
+	// os.File keeps writing until all bytes written or error happened
+	n, err := s.f.Write(data)
+	if isTimeout(err) {
+		return n, &WriteTimeoutError{Written: n}
+	}
+
+	return n, err
+}
+
+// setReadDeadline applies read deadline to the file
+func (s *SerialPort) setReadDeadline(t time.Time) error {
+	if !s.pollable {
+		return ErrNotSupported
+	}
+	return s.f.SetReadDeadline(t)
+}
+
+// setWriteDeadline applies write deadline to the file
+func (s *SerialPort) setWriteDeadline(t time.Time) error {
+	if !s.pollable {
+		return ErrNotSupported
+	}
+	return s.f.SetWriteDeadline(t)
+}
+
+// readConfig decodes serial port config from the device termios
+func (s *SerialPort) readConfig() (Config, error) {
+	tty, err := unix.IoctlGetTermios(int(s.f.Fd()), termiosReqGet)
+	if err != nil {
+		return Config{}, fmt.Errorf("fail to get serial port config: %v", err)
+	}
+
+	var (
+		c     Config
+		cflag = uint64(tty.Cflag)
+		iflag = uint64(tty.Iflag)
+	)
+
+	inputBaudRate, outputBaudRate := getTermiosSpeed(tty)
+	c.BaudRate, c.InputBaudRate = int(outputBaudRate), int(inputBaudRate)
+
+	switch cflag & maskDataBits {
+	case dataBits5:
+		c.DataBits = 5
+	case dataBits6:
+		c.DataBits = 6
+	case dataBits7:
+		c.DataBits = 7
+	case dataBits8:
+		c.DataBits = 8
+	}
+
+	switch {
+	case cflag&parityEnable == 0:
+		c.Parity = ParityNone
+	case ParityMark != 0 && cflag&uint64(ParityMark) != 0:
+		c.Parity = ParityMark
+	case cflag&uint64(ParityOdd) != 0:
+		c.Parity = ParityOdd
+	default:
+		c.Parity = ParityEven
+	}
+
+	c.StopBits = StopBitOne
+	if cflag&uint64(StopBitTwo) != 0 {
+		c.StopBits = StopBitTwo
+	}
+
+	c.SoftwareFlowControl = iflag&(unix.IXON|unix.IXOFF) != 0
+	c.HardwareFlowControl = cflag&hardwareCtrlFlag != 0
+	c.MarkLineErrors = iflag&unix.PARMRK != 0
+	c.InputTranslation, c.OutputTranslation = getTranslation(tty)
+	c.Canonical = getCanonicalMode(tty)
+
+	// read timeout only takes effect without minimum bytes to read
+	vmin, vtime := tty.Cc[unix.VMIN], tty.Cc[unix.VTIME]
+	switch {
+	case vmin == 0:
+		c.ReadTimeout = time.Duration(vtime) * 100 * time.Millisecond
+	case vmin > 1 || vtime > 0:
+		// VMIN is 255 when only inter-character timeout set
+		if vmin != math.MaxUint8 || vtime == 0 {
+			c.MinReadBytes = int(vmin)
+		}
+		c.InterCharTimeout = time.Duration(vtime) * 100 * time.Millisecond
+
+		// read timeout is emulated with deadline in this case
+		c.ReadTimeout = s.options().readTimeout
+	}
+
+	return c, nil
+}
+
+// setModemLines set (on) or clear (off) modem control lines
+func (s *SerialPort) setModemLines(lines ModemLine, on bool) error {
+	return setModemLines(s.fdIoctl, lines, on)
+}
+
+// setModemLines set (TIOCMBIS) or clear (TIOCMBIC) modem control lines
+func setModemLines(do ioctlFunc, lines ModemLine, on bool) error {
+	req := uint(unix.TIOCMBIC)
+	if on {
+		req = unix.TIOCMBIS
+	}
+
+	v := int32(lines)
+	return do(req, unsafe.Pointer(&v))
+}
+
+// getModemLines returns modem lines which are set
+func (s *SerialPort) getModemLines() (ModemLine, error) {
+	return getModemLines(s.fdIoctl)
+}
+
+// getModemLines returns modem lines which are set (TIOCMGET)
+func getModemLines(do ioctlFunc) (ModemLine, error) {
+	v := int32(0)
+	if err := do(unix.TIOCMGET, unsafe.Pointer(&v)); err != nil {
+		return 0, err
+	}
+	return ModemLine(v), nil
+}
+
+// queueLength returns bytes waiting in the input or output queue
+func (s *SerialPort) queueLength(q queueSelector) (int, error) {
+	req := queueReqInput
+	if q == outputQueue {
+		req = queueReqOutput
+	}
+
+	v := int32(0)
+	if err := ioctl(s.f.Fd(), req, uintptr(unsafe.Pointer(&v))); err != nil {
+		return 0, err
+	}
+	return int(v), nil
+}
+
+// setBreak start (on) or stop (off) sending break condition
+func (s *SerialPort) setBreak(on bool) error {
+	return setBreak(s.fdIoctl, on)
+}
+
+// setBreak starts (TIOCSBRK) or stops (TIOCCBRK) sending break condition
+func setBreak(do ioctlFunc, on bool) error {
+	req := uint(unix.TIOCCBRK)
+	if on {
+		req = unix.TIOCSBRK
+	}
+
+	return do(req, nil)
+}
+
+// hungUp checks whether the serial port has been hung up,
+// read returns zero bytes (io.EOF) after hung up
+func (s *SerialPort) hungUp() bool {
+	fds := []unix.PollFd{{Fd: int32(s.f.Fd()), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, 0)
+	return err == nil && n > 0 && fds[0].Revents&unix.POLLHUP != 0
+}
+
+// isDisconnectError checks whether err is caused by device disconnected
+func isDisconnectError(err error) bool {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+
+	switch err {
+	case unix.EIO, unix.ENXIO, unix.ENODEV, unix.EBADF:
+		return true
+	}
+	return false
+}
+
+// ioctlFunc performs ioctl request with argument on the serial port,
+// it's replaced by fake ones in tests
+type ioctlFunc func(req uint, arg unsafe.Pointer) error
+
+// fdIoctl performs ioctl request on the file of serial port
+func (s *SerialPort) fdIoctl(req uint, arg unsafe.Pointer) error {
+	return ioctl(s.f.Fd(), req, uintptr(arg))
+}
+
+// ioctl performs raw ioctl request with argument on fd
+func ioctl(fd uintptr, req uint, arg uintptr) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, uintptr(req), arg)
+	if errno != 0 {
+		return errno
+	}
 	return nil
 }
@@ -0,0 +1,120 @@
+// +build !windows
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// fakeDriver is a fake tty driver handling ioctl requests with handlers
+// registered, requests are logged and ENOTTY is returned if not handled
+type fakeDriver struct {
+	mu       sync.Mutex
+	handlers map[uint]func(arg unsafe.Pointer) error
+	calls    []fakeCall
+}
+
+// fakeCall is an ioctl request received by fakeDriver
+type fakeCall struct {
+	req uint
+	at  time.Time
+}
+
+func newFakeDriver() *fakeDriver {
+	return &fakeDriver{handlers: make(map[uint]func(arg unsafe.Pointer) error)}
+}
+
+// handle registers handler of the request, handlers are called with driver locked
+func (d *fakeDriver) handle(req uint, h func(arg unsafe.Pointer) error) {
+	d.locked(func() { d.handlers[req] = h })
+}
+
+// ioctl is the ioctlFunc of the driver
+func (d *fakeDriver) ioctl(req uint, arg unsafe.Pointer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.calls = append(d.calls, fakeCall{req: req, at: time.Now()})
+
+	h, ok := d.handlers[req]
+	if !ok {
+		return unix.ENOTTY
+	}
+	return h(arg)
+}
+
+// locked runs f with driver locked, to change the state used by handlers
+func (d *fakeDriver) locked(f func()) {
+	d.mu.Lock()
+	f()
+	d.mu.Unlock()
+}
+
+// requests returns all requests received in order
+func (d *fakeDriver) requests() []uint {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	reqs := make([]uint, len(d.calls))
+	for i, c := range d.calls {
+		reqs[i] = c.req
+	}
+	return reqs
+}
+
+func TestSerialPort_SendBreak(t *testing.T) {
+	d := newFakeDriver()
+	d.handle(unix.TIOCSBRK, func(unsafe.Pointer) error { return nil })
+	d.handle(unix.TIOCCBRK, func(unsafe.Pointer) error { return nil })
+
+	if err := sendBreak(func(on bool) error { return setBreak(d.ioctl, on) }, 100*time.Millisecond); err != nil {
+		t.Fatalf("send break failed: %v", err)
+	}
+
+	if reqs := d.requests(); !reflect.DeepEqual(reqs, []uint{unix.TIOCSBRK, unix.TIOCCBRK}) {
+		t.Fatalf("break requests not correct: %#x", reqs)
+	}
+
+	if duration := d.calls[1].at.Sub(d.calls[0].at); duration < 100*time.Millisecond {
+		t.Errorf("break duration not correct: %v", duration)
+	}
+
+	// break is not cleared if it failed to set
+	d = newFakeDriver()
+	if err := sendBreak(func(on bool) error { return setBreak(d.ioctl, on) }, time.Millisecond); err != unix.ENOTTY {
+		t.Errorf("send break error not correct: %v", err)
+	}
+
+	if reqs := d.requests(); !reflect.DeepEqual(reqs, []uint{unix.TIOCSBRK}) {
+		t.Errorf("break requests not correct: %#x", reqs)
+	}
+}
+
+func TestWithWriteTimeout_NotPollable(t *testing.T) {
+	s := &SerialPort{portOptions: portOptions{writeTimeout: time.Second}}
+	if err := s.configure(); err != ErrNotSupported {
+		t.Errorf("write timeout on non-pollable file should fail with ErrNotSupported: %v", err)
+	}
+}
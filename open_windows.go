@@ -19,27 +19,47 @@
 package libserial
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"os"
+	"sync/atomic"
 	"syscall"
+	"time"
 	"unsafe"
 
 	win "golang.org/x/sys/windows"
 )
 
 const (
-	SetCommState    = "SetCommState"
-	SetCommTimeouts = "SetCommTimeouts"
-	SetCommMask     = "SetCommMask"
-	SetupComm       = "SetupComm"
-	PurgeComm       = "PurgeComm"
+	SetCommState        = "SetCommState"
+	SetCommTimeouts     = "SetCommTimeouts"
+	SetCommMask         = "SetCommMask"
+	SetupComm           = "SetupComm"
+	PurgeComm           = "PurgeComm"
+	FlushFileBuffers    = "FlushFileBuffers"
+	GetCommState        = "GetCommState"
+	GetCommTimeouts     = "GetCommTimeouts"
+	EscapeCommFunction  = "EscapeCommFunction"
+	GetCommModemStatus  = "GetCommModemStatus"
+	ClearCommError      = "ClearCommError"
+	GetOverlappedResult = "GetOverlappedResult"
 )
 
 var (
-	comSyscall     = map[string]func(s *SerialPort) error{}
-	comSyscallList = []string{
+	comSyscall      = map[string]func(s *SerialPort) error{}
+	comQuerySyscall = map[string]func(s *SerialPort, v unsafe.Pointer) error{}
+	comArgSyscall   = map[string]func(s *SerialPort, arg uintptr) error{}
+	comSyscallList  = []string{
 		SetCommState, SetupComm, SetCommTimeouts, SetCommMask, PurgeComm,
+		FlushFileBuffers, GetCommState, GetCommTimeouts,
+		EscapeCommFunction, GetCommModemStatus, ClearCommError,
+		GetOverlappedResult,
+	}
+	// syscalls to setup serial port before configure when opening
+	comSetupSyscallList = []string{
+		SetupComm, SetCommMask,
 	}
 )
 
@@ -48,11 +68,24 @@ func (s *SerialPort) open() error {
 		s.dev = `\\.\` + s.dev
 	}
 
-	f, err := os.OpenFile(s.dev, os.O_RDWR, 0)
+	name, err := win.UTF16PtrFromString(s.dev)
 	if err != nil {
 		return err
 	}
 
+	// com port can only be opened by one process at a time, opened for
+	// overlapped i/o so that a pending read or write can be canceled alone
+	h, err := win.CreateFile(name, win.GENERIC_READ|win.GENERIC_WRITE, 0, nil,
+		win.OPEN_EXISTING, win.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		if err == win.ERROR_ACCESS_DENIED {
+			return ErrPortBusy
+		}
+		return &os.PathError{Op: "open", Path: s.dev, Err: err}
+	}
+
+	f := os.NewFile(uintptr(h), s.dev)
+
 	defer func() {
 		if err != nil {
 			f.Close()
@@ -60,21 +93,484 @@ func (s *SerialPort) open() error {
 	}()
 
 	s.f = f
-	for _, name := range comSyscallList {
-		if name != PurgeComm {
-			if err = comSyscall[name](s); err != nil {
-				return err
+	for _, name := range comSetupSyscallList {
+		if err = comSyscall[name](s); err != nil {
+			return err
+		}
+	}
+
+	if s.inheritSettings {
+		err = s.inherit()
+	} else {
+		err = s.configure()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	s.flush = func(d flushDirection) error {
+		flags := uintptr(0)
+		if d&flushInput != 0 {
+			// PURGE_RXABORT | PURGE_RXCLEAR
+			flags |= 0x000A
+		}
+
+		if d&flushOutput != 0 {
+			// PURGE_TXABORT | PURGE_TXCLEAR
+			flags |= 0x0005
+		}
+
+		return comArgSyscall[PurgeComm](s, flags)
+	}
+
+	s.drain = func() error {
+		return comSyscall[FlushFileBuffers](s)
+	}
+
+	return nil
+}
+
+// close serial port
+func (s *SerialPort) close() error {
+	return s.f.Close()
+}
+
+// configure applies current options to the opened serial port
+func (s *SerialPort) configure() error {
+	// there is only one baud rate in DCB
+	if s.inputBaudRate != s.baudRate {
+		return fmt.Errorf("separate input and output baud rates are not supported: %v", ErrNotSupported)
+	}
+
+	switch s.applyWhen {
+	case ApplyAfterDrain:
+		if err := comSyscall[FlushFileBuffers](s); err != nil {
+			return err
+		}
+	case ApplyAfterFlush:
+		if err := comSyscall[FlushFileBuffers](s); err != nil {
+			return err
+		}
+
+		// PURGE_RXABORT | PURGE_RXCLEAR
+		if err := comArgSyscall[PurgeComm](s, 0x000A); err != nil {
+			return err
+		}
+	}
+
+	if err := comSyscall[SetCommState](s); err != nil {
+		return err
+	}
+
+	return comQuerySyscall[SetCommTimeouts](s, unsafe.Pointer(s.commTimeouts()))
+}
+
+// commTimeouts returns timeouts for blocking read with read timeout
+func (o *portOptions) commTimeouts() *_commTimeouts {
+	timeout := &_commTimeouts{
+		ReadIntervalTimeout:        math.MaxUint32,
+		ReadTotalTimeoutMultiplier: math.MaxUint32,
+		ReadTotalTimeoutConstant:   math.MaxUint32 - 1,
+	}
+
+	if o.readTimeout > 0 {
+		timeout.ReadTotalTimeoutConstant = durationToMillis(o.readTimeout)
+	}
+
+	// read until buffer filled or gap exceeded, with total timeout (zero means none)
+	if o.interCharTimeout > 0 {
+		timeout.ReadIntervalTimeout = durationToMillis(o.interCharTimeout)
+		timeout.ReadTotalTimeoutMultiplier = 0
+		timeout.ReadTotalTimeoutConstant = 0
+		if o.readTimeout > 0 {
+			timeout.ReadTotalTimeoutConstant = durationToMillis(o.readTimeout)
+		}
+	}
+
+	return timeout
+}
+
+// read bytes from serial port, input translation is emulated in userspace
+func (s *SerialPort) read(data []byte) (int, error) {
+	opts := s.options()
+	for {
+		n, err := s.readRaw(data, &opts)
+		if opts.inputTranslation == 0 {
+			return n, err
+		}
+
+		// read again if all bytes ignored
+		m := translateInput(opts.inputTranslation, data[:n])
+		if m > 0 || n == 0 || err != nil {
+			return m, err
+		}
+	}
+}
+
+// readRaw reads bytes from serial port, min read bytes is emulated by limiting
+// the buffer with inter-character timeout, or reading repeatedly without it
+func (s *SerialPort) readRaw(data []byte, opts *portOptions) (int, error) {
+	min := opts.minReadBytes
+	if min > len(data) {
+		min = len(data)
+	}
+
+	buf := data
+	if min > 0 && opts.interCharTimeout > 0 {
+		buf = data[:min]
+	}
+
+	n, err := s.readOnce(buf, opts)
+	for err == nil && n < min && opts.interCharTimeout == 0 {
+		m, e := s.readOnce(data[n:], opts)
+		n += m
+		if e != nil {
+			// return bytes already read, timeout will be reported by next read
+			if e != ErrTimeout && e != errReadTimeout && e != io.EOF {
+				err = e
 			}
+			break
+		}
+	}
+
+	return n, err
+}
+
+// readOnce reads bytes from serial port, read deadline is emulated with comm timeouts
+func (s *SerialPort) readOnce(data []byte, opts *portOptions) (int, error) {
+	s.deadlineMu.Lock()
+	deadline := s.readDeadline
+	s.deadlineMu.Unlock()
+
+	if deadline.IsZero() {
+		n, err := s.readFile(data)
+		if n == 0 && err == io.EOF && opts.readTimeout > 0 {
+			return 0, errReadTimeout
+		}
+		return n, err
+	}
+
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return 0, ErrTimeout
+	}
+
+	timeout := opts.commTimeouts()
+	timeout.ReadTotalTimeoutConstant = durationToMillis(remaining)
+	if err := comQuerySyscall[SetCommTimeouts](s, unsafe.Pointer(timeout)); err != nil {
+		return 0, err
+	}
+	defer comQuerySyscall[SetCommTimeouts](s, unsafe.Pointer(opts.commTimeouts()))
+
+	n, err := s.readFile(data)
+	if n == 0 && (err == nil || err == io.EOF || err == errCanceled) {
+		return 0, ErrTimeout
+	}
+
+	if err == errCanceled {
+		err = nil
+	}
+	return n, err
+}
+
+// readFile reads bytes like os.File, io.EOF is returned if nothing read
+func (s *SerialPort) readFile(data []byte) (int, error) {
+	n, err := s.overlappedIO(&s.pendingRead, s.readDeadlineExceeded,
+		func(h win.Handle, done *uint32, ov *win.Overlapped) error {
+			return win.ReadFile(h, data, done, ov)
+		})
+	if n == 0 && err == nil && len(data) > 0 {
+		return 0, io.EOF
+	}
+
+	return n, err
+}
+
+// readDeadlineExceeded checks whether read deadline set and exceeded
+func (s *SerialPort) readDeadlineExceeded() bool {
+	s.deadlineMu.Lock()
+	defer s.deadlineMu.Unlock()
+
+	return !s.readDeadline.IsZero() && !time.Now().Before(s.readDeadline)
+}
+
+// writeDeadlineExceeded checks whether write deadline set and exceeded
+func (s *SerialPort) writeDeadlineExceeded() bool {
+	s.deadlineMu.Lock()
+	defer s.deadlineMu.Unlock()
+
+	return !s.writeDeadline.IsZero() && !time.Now().Before(s.writeDeadline)
+}
+
+// errCanceled happens when overlapped i/o canceled by deadline
+var errCanceled = errors.New("serial port i/o canceled")
+
+// overlappedIO issues read or write with its own OVERLAPPED and waits until
+// done, pending is set to cancel this operation alone, the operation is also
+// canceled if exceeded() reports deadline exceeded after it's pending
+func (s *SerialPort) overlappedIO(pending *pendingIO, exceeded func() bool,
+	issue func(h win.Handle, done *uint32, ov *win.Overlapped) error) (int, error) {
+	event, err := win.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer win.CloseHandle(event)
+
+	// low-order bit of event prevents completion from being queued
+	// to the completion port associated by os.File
+	h := win.Handle(s.f.Fd())
+	ov := &win.Overlapped{HEvent: event | 1}
+	done := uint32(0)
+	err = issue(h, &done, ov)
+	if err == nil {
+		return int(done), nil
+	}
+
+	if err != win.ERROR_IO_PENDING {
+		return int(done), err
+	}
+
+	canceled := int32(0)
+	cancel := func() {
+		atomic.StoreInt32(&canceled, 1)
+		win.CancelIoEx(h, ov)
+	}
+
+	// the deadline is set before pending is canceled, check it after
+	// pending set to not miss the cancellation
+	pending.set(cancel)
+	defer pending.set(nil)
+	if exceeded() {
+		cancel()
+	}
+
+	// wait on the event rather than the file, which may be closed meanwhile
+	if _, err = win.WaitForSingleObject(event, win.INFINITE); err != nil {
+		return 0, err
+	}
+
+	err = comQuerySyscall[GetOverlappedResult](s, unsafe.Pointer(ov))
+	n := int(ov.InternalHigh)
+	if err != nil && atomic.LoadInt32(&canceled) == 1 {
+		return n, errCanceled
+	}
+
+	return n, err
+}
+
+// write bytes to serial port, output translation is emulated in userspace
+func (s *SerialPort) write(data []byte) (int, error) {
+	opts := s.options()
+	if opts.outputTranslation == 0 {
+		return s.writeRaw(data, &opts)
+	}
+
+	out, ends := translateOutput(opts.outputTranslation, data)
+	n, err := s.writeRaw(out, &opts)
+	if n == len(out) {
+		return len(data), err
+	}
+
+	// report count of bytes written before translation
+	written := translatedWritten(ends, n)
+	if e, ok := err.(*WriteTimeoutError); ok {
+		e.Written = written
+	}
+	return written, err
+}
+
+// writeRaw writes bytes to serial port, write deadline and write timeout are emulated with comm timeouts
+func (s *SerialPort) writeRaw(data []byte, opts *portOptions) (int, error) {
+	s.deadlineMu.Lock()
+	deadline := s.writeDeadline
+	if deadline.IsZero() && opts.writeTimeout > 0 {
+		deadline = time.Now().Add(opts.writeTimeout)
+	}
+	s.deadlineMu.Unlock()
+
+	if deadline.IsZero() {
+		n, err := s.writeFile(data)
+		if err == nil && n < len(data) {
+			err = io.ErrShortWrite
 		}
+		return n, err
 	}
 
-	s.flush = func() error {
-		return comSyscall[PurgeComm](s)
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return 0, &WriteTimeoutError{}
 	}
 
+	timeout := opts.commTimeouts()
+	timeout.WriteTotalTimeoutConstant = durationToMillis(remaining)
+	if err := comQuerySyscall[SetCommTimeouts](s, unsafe.Pointer(timeout)); err != nil {
+		return 0, err
+	}
+	defer comQuerySyscall[SetCommTimeouts](s, unsafe.Pointer(opts.commTimeouts()))
+
+	n, err := s.writeFile(data)
+	if err != nil && err != errCanceled {
+		return n, err
+	}
+
+	if n < len(data) {
+		return n, &WriteTimeoutError{Written: n}
+	}
+
+	return n, nil
+}
+
+// writeFile writes bytes once with WriteFile, short write is not retried
+func (s *SerialPort) writeFile(data []byte) (int, error) {
+	return s.overlappedIO(&s.pendingWrite, s.writeDeadlineExceeded,
+		func(h win.Handle, done *uint32, ov *win.Overlapped) error {
+			return win.WriteFile(h, data, done, ov)
+		})
+}
+
+// setReadDeadline does nothing, read deadline is applied when reading
+func (s *SerialPort) setReadDeadline(t time.Time) error {
 	return nil
 }
 
+// setWriteDeadline does nothing, write deadline is applied when writing
+func (s *SerialPort) setWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// hungUp always returns false, disconnection is reported as error
+func (s *SerialPort) hungUp() bool {
+	return false
+}
+
+// isDisconnectError checks whether err is caused by device disconnected
+func isDisconnectError(err error) bool {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+
+	switch err {
+	case win.ERROR_ACCESS_DENIED,
+		syscall.Errno(22),   // ERROR_BAD_COMMAND
+		syscall.Errno(31),   // ERROR_GEN_FAILURE
+		syscall.Errno(995),  // ERROR_OPERATION_ABORTED
+		syscall.Errno(1167): // ERROR_DEVICE_NOT_CONNECTED
+		return true
+	}
+	return false
+}
+
+// durationToMillis converts d to milliseconds used by comm timeouts,
+// at least 1ms since zero means no timeout
+func durationToMillis(d time.Duration) uint32 {
+	ms := d.Nanoseconds() / 1e6
+	switch {
+	case ms < 1:
+		return 1
+	case ms > math.MaxUint32-2:
+		return math.MaxUint32 - 2
+	}
+	return uint32(ms)
+}
+
+// readConfig decodes serial port config from the device DCB and timeouts
+func (s *SerialPort) readConfig() (Config, error) {
+	d := &_dcb{}
+	if err := comQuerySyscall[GetCommState](s, unsafe.Pointer(d)); err != nil {
+		return Config{}, err
+	}
+
+	timeout := &_commTimeouts{}
+	if err := comQuerySyscall[GetCommTimeouts](s, unsafe.Pointer(timeout)); err != nil {
+		return Config{}, err
+	}
+
+	c := Config{
+		BaudRate:      int(d.BaudRate),
+		InputBaudRate: int(d.BaudRate),
+		DataBits:      int(d.ByteSize),
+		Parity:        Parity(d.Parity),
+		StopBits:      StopBit(d.StopBits),
+		// fOutX | fInX
+		SoftwareFlowControl: d.flags[1]&0x03 != 0,
+		// fOutxCtsFlow
+		HardwareFlowControl: d.flags[0]&0x04 != 0,
+	}
+
+	if timeout.ReadTotalTimeoutConstant != math.MaxUint32-1 {
+		c.ReadTimeout = time.Duration(timeout.ReadTotalTimeoutConstant) * time.Millisecond
+	}
+
+	if timeout.ReadIntervalTimeout != math.MaxUint32 {
+		c.InterCharTimeout = time.Duration(timeout.ReadIntervalTimeout) * time.Millisecond
+	}
+
+	return c, nil
+}
+
+// setModemLines set (on) or clear (off) modem control lines
+func (s *SerialPort) setModemLines(lines ModemLine, on bool) error {
+	// SETRTS = 3, CLRRTS = 4, SETDTR = 5, CLRDTR = 6
+	escapes := map[ModemLine][2]uintptr{
+		ModemLineRTS: {4, 3},
+		ModemLineDTR: {6, 5},
+	}
+
+	for line, escape := range escapes {
+		if lines&line == 0 {
+			continue
+		}
+
+		code := escape[0]
+		if on {
+			code = escape[1]
+		}
+
+		if err := comArgSyscall[EscapeCommFunction](s, code); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setBreak start (on) or stop (off) sending break condition
+func (s *SerialPort) setBreak(on bool) error {
+	// SETBREAK = 8, CLRBREAK = 9
+	code := uintptr(9)
+	if on {
+		code = 8
+	}
+
+	return comArgSyscall[EscapeCommFunction](s, code)
+}
+
+// getModemLines returns modem status lines which are set
+func (s *SerialPort) getModemLines() (ModemLine, error) {
+	status := uint32(0)
+	if err := comQuerySyscall[GetCommModemStatus](s, unsafe.Pointer(&status)); err != nil {
+		return 0, err
+	}
+	return ModemLine(status), nil
+}
+
+// queueLength returns bytes waiting in the input or output queue
+func (s *SerialPort) queueLength(q queueSelector) (int, error) {
+	stat := &_comStat{}
+	if err := comQuerySyscall[ClearCommError](s, unsafe.Pointer(stat)); err != nil {
+		return 0, err
+	}
+
+	if q == outputQueue {
+		return int(stat.cbOutQue), nil
+	}
+	return int(stat.cbInQue), nil
+}
+
 func init() {
 	dll, err := win.LoadLibrary("kernel32.dll")
 
@@ -93,17 +589,76 @@ func init() {
 
 		rawSyscall[name] = func(args ...uintptr) (uintptr, error) {
 			n := uintptr(len(args))
-			args = append(args, make([]uintptr, 3-n)...)
-			r, _, err := syscall.Syscall(addr, n, args[0], args[1], args[2])
+			args = append(args, make([]uintptr, 6-n)...)
+			r, _, err := syscall.Syscall6(addr, n, args[0], args[1], args[2], args[3], args[4], args[5])
 			return r, fmt.Errorf("syscall %s failed: %v", name, err)
 		}
 	}
 
 	// wrap raw syscalls for setup ease
 	{
-		comSyscall[PurgeComm] = func(s *SerialPort) error {
-			// 0x000F = (PURGE_TXABORT | PURGE_RXABORT | PURGE_TXCLEAR | PURGE_RXCLEAR)
-			r, err := rawSyscall[PurgeComm](s.f.Fd(), 0x000F)
+		comQuerySyscall[GetCommState] = func(s *SerialPort, v unsafe.Pointer) error {
+			(*_dcb)(v).DCBLength = _dcbSize
+			r, err := rawSyscall[GetCommState](s.f.Fd(), uintptr(v))
+			if r == 0 {
+				return err
+			}
+			return nil
+		}
+
+		comQuerySyscall[GetCommModemStatus] = func(s *SerialPort, v unsafe.Pointer) error {
+			r, err := rawSyscall[GetCommModemStatus](s.f.Fd(), uintptr(v))
+			if r == 0 {
+				return err
+			}
+			return nil
+		}
+
+		comQuerySyscall[ClearCommError] = func(s *SerialPort, v unsafe.Pointer) error {
+			r, err := rawSyscall[ClearCommError](s.f.Fd(), 0, uintptr(v))
+			if r == 0 {
+				return err
+			}
+			return nil
+		}
+
+		comArgSyscall[EscapeCommFunction] = func(s *SerialPort, arg uintptr) error {
+			r, err := rawSyscall[EscapeCommFunction](s.f.Fd(), arg)
+			if r == 0 {
+				return err
+			}
+			return nil
+		}
+
+		// query result of completed i/o without waiting,
+		// bytes transferred are stored in InternalHigh of the OVERLAPPED
+		comQuerySyscall[GetOverlappedResult] = func(s *SerialPort, v unsafe.Pointer) error {
+			done := uint32(0)
+			r, err := rawSyscall[GetOverlappedResult](s.f.Fd(), uintptr(v), uintptr(unsafe.Pointer(&done)), 0)
+			if r == 0 {
+				return err
+			}
+			return nil
+		}
+
+		comQuerySyscall[GetCommTimeouts] = func(s *SerialPort, v unsafe.Pointer) error {
+			r, err := rawSyscall[GetCommTimeouts](s.f.Fd(), uintptr(v))
+			if r == 0 {
+				return err
+			}
+			return nil
+		}
+
+		comSyscall[FlushFileBuffers] = func(s *SerialPort) error {
+			r, err := rawSyscall[FlushFileBuffers](s.f.Fd())
+			if r == 0 {
+				return err
+			}
+			return nil
+		}
+
+		comArgSyscall[PurgeComm] = func(s *SerialPort, flags uintptr) error {
+			r, err := rawSyscall[PurgeComm](s.f.Fd(), flags)
 			if r == 0 {
 				return err
 			}
@@ -127,25 +682,8 @@ func init() {
 			return nil
 		}
 
-		comSyscall[SetCommTimeouts] = func(s *SerialPort) error {
-			timeout := &struct {
-				ReadIntervalTimeout         uint32
-				ReadTotalTimeoutMultiplier  uint32
-				ReadTotalTimeoutConstant    uint32
-				WriteTotalTimeoutMultiplier uint32
-				WriteTotalTimeoutConstant   uint32
-			}{
-				ReadIntervalTimeout:        math.MaxUint32,
-				ReadTotalTimeoutMultiplier: math.MaxUint32,
-				ReadTotalTimeoutConstant: func() uint32 {
-					if s.readTimeout == 0 {
-						return math.MaxUint32 - 1
-					}
-					return uint32(s.readTimeout.Nanoseconds() / 1e6)
-				}(),
-			}
-
-			r, err := rawSyscall[SetCommTimeouts](s.f.Fd(), uintptr(unsafe.Pointer(timeout)))
+		comQuerySyscall[SetCommTimeouts] = func(s *SerialPort, v unsafe.Pointer) error {
+			r, err := rawSyscall[SetCommTimeouts](s.f.Fd(), uintptr(v))
 			if r == 0 {
 				return err
 			}
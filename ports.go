@@ -0,0 +1,46 @@
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+// PortInfo describes a serial port found by ListPorts
+type PortInfo struct {
+	// Path of the device, e.g. /dev/ttyUSB0
+	Path string
+	// Name of the device, e.g. ttyUSB0
+	Name string
+	// Driver of the device, e.g. ftdi_sio, cdc_acm
+	Driver string
+
+	// USB is true if the port is provided by an usb adapter,
+	// following fields are empty if not
+	USB          bool
+	VID          uint16
+	PID          uint16
+	SerialNumber string
+	Manufacturer string
+	Product      string
+	// Interface number of the usb interface providing the port
+	Interface int
+	// USBPath is the physical path of the usb device, e.g. 1-1.2
+	USBPath string
+}
+
+// ListPorts returns serial ports available in the system
+// only supported on linux
+func ListPorts() ([]PortInfo, error) {
+	return listPorts()
+}
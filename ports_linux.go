@@ -0,0 +1,138 @@
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sysfsRoot is where sysfs mounted, replaced in tests
+var sysfsRoot = "/sys"
+
+// max levels to look up from tty device to usb interface
+const maxUSBLookupDepth = 4
+
+// listPorts returns serial ports available in the system
+func listPorts() ([]PortInfo, error) {
+	return listSysfsPorts(sysfsRoot)
+}
+
+// listSysfsPorts returns serial ports found in /class/tty of sysfs root,
+// virtual terminals and unused serial8250 ports are skipped
+func listSysfsPorts(root string) ([]PortInfo, error) {
+	classDir := filepath.Join(root, "class", "tty")
+	entries, err := ioutil.ReadDir(classDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []PortInfo
+	for _, entry := range entries {
+		name := entry.Name()
+
+		// virtual terminals have no device
+		devDir, err := filepath.EvalSymlinks(filepath.Join(classDir, name, "device"))
+		if err != nil {
+			continue
+		}
+
+		// serial core reports type 0 (PORT_UNKNOWN) for ports without hardware
+		if t, err := readSysfsAttr(filepath.Join(classDir, name), "type"); err == nil && t == "0" {
+			continue
+		}
+
+		// serial core adds ctrl and port devices of serial-base bus since linux 6.5
+		for linkName(filepath.Join(devDir, "subsystem")) == "serial-base" {
+			devDir = filepath.Dir(devDir)
+		}
+
+		port := PortInfo{
+			Path:   "/dev/" + name,
+			Name:   name,
+			Driver: linkName(filepath.Join(devDir, "driver")),
+		}
+
+		// usb-serial ports (ttyUSB) are children of the usb interface,
+		// while cdc-acm ports (ttyACM) are provided by the interface itself
+		dir := devDir
+		for i := 0; i < maxUSBLookupDepth; i++ {
+			if fillUSBInfo(&port, dir) {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+
+		ports = append(ports, port)
+	}
+
+	return ports, nil
+}
+
+// fillUSBInfo fills usb info if dir is an usb interface
+func fillUSBInfo(port *PortInfo, dir string) bool {
+	ifNum, err := readSysfsAttr(dir, "bInterfaceNumber")
+	if err != nil {
+		return false
+	}
+
+	// usb device is the parent of usb interface
+	usbDir := filepath.Dir(dir)
+	vid, err := readSysfsAttr(usbDir, "idVendor")
+	if err != nil {
+		return false
+	}
+	pid, _ := readSysfsAttr(usbDir, "idProduct")
+
+	port.USB = true
+	port.USBPath = filepath.Base(usbDir)
+	port.VID = uint16(parseHex(vid))
+	port.PID = uint16(parseHex(pid))
+	port.Interface = int(parseHex(ifNum))
+	port.SerialNumber, _ = readSysfsAttr(usbDir, "serial")
+	port.Manufacturer, _ = readSysfsAttr(usbDir, "manufacturer")
+	port.Product, _ = readSysfsAttr(usbDir, "product")
+
+	return true
+}
+
+// readSysfsAttr returns content of the attribute file with spaces trimmed
+func readSysfsAttr(dir, name string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// linkName returns base name of the symlink target, empty if not found
+func linkName(path string) string {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// parseHex parses hex value in sysfs attribute, zero if invalid
+func parseHex(s string) uint64 {
+	v, _ := strconv.ParseUint(s, 16, 16)
+	return v
+}
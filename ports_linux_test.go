@@ -0,0 +1,149 @@
+// +build linux
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// makeSysfsFixture creates a sysfs tree with usb-serial, cdc-acm,
+// serial8250 ports (with and without serial-base bus) and a virtual terminal
+func makeSysfsFixture(t *testing.T, root string) {
+	dirs := map[string]map[string]string{
+		"devices/pci0000:00/usb1/1-1": {
+			"idVendor": "0403\n", "idProduct": "6001\n", "serial": "A50285BI\n",
+			"manufacturer": "FTDI\n", "product": "FT232R USB UART\n",
+		},
+		"devices/pci0000:00/usb1/1-1/1-1:1.0":                     {"bInterfaceNumber": "00\n"},
+		"devices/pci0000:00/usb1/1-1/1-1:1.0/ttyUSB0":             nil,
+		"devices/pci0000:00/usb1/1-1/1-1:1.0/ttyUSB0/tty/ttyUSB0": nil,
+		"devices/pci0000:00/usb1/1-3": {
+			"idVendor": "0403\n", "idProduct": "6001\n", "serial": "A9K3B2QX\n",
+		},
+		"devices/pci0000:00/usb1/1-3/1-3:1.0":                     {"bInterfaceNumber": "00\n"},
+		"devices/pci0000:00/usb1/1-3/1-3:1.0/ttyUSB1/tty/ttyUSB1": nil,
+		"devices/pci0000:00/usb1/1-2": {
+			"idVendor": "2341\n", "idProduct": "0043\n",
+			"manufacturer": "Arduino (www.arduino.cc)\n",
+		},
+		"devices/pci0000:00/usb1/1-2/1-2:1.2":             {"bInterfaceNumber": "02\n"},
+		"devices/pci0000:00/usb1/1-2/1-2:1.2/tty/ttyACM0": nil,
+		"devices/platform/serial8250":                     nil,
+		"devices/platform/serial8250/tty/ttyS0":           {"type": "4\n"},
+		"devices/platform/serial8250/tty/ttyS1":           {"type": "0\n"},
+		"devices/pnp0/00:01/00:01:0/00:01:0.0/tty/ttyS2":  {"type": "4\n"},
+		"devices/virtual/tty/tty0":                        nil,
+		"bus/usb-serial/drivers/ftdi_sio":                 nil,
+		"bus/usb/drivers/cdc_acm":                         nil,
+		"bus/platform/drivers/serial8250":                 nil,
+		"bus/pnp/drivers/serial":                          nil,
+		"bus/serial-base":                                 nil,
+		"class/tty":                                       nil,
+	}
+
+	links := map[string]string{
+		"class/tty/ttyUSB0": "devices/pci0000:00/usb1/1-1/1-1:1.0/ttyUSB0/tty/ttyUSB0",
+		"class/tty/ttyUSB1": "devices/pci0000:00/usb1/1-3/1-3:1.0/ttyUSB1/tty/ttyUSB1",
+		"class/tty/ttyACM0": "devices/pci0000:00/usb1/1-2/1-2:1.2/tty/ttyACM0",
+		"class/tty/ttyS0":   "devices/platform/serial8250/tty/ttyS0",
+		"class/tty/ttyS1":   "devices/platform/serial8250/tty/ttyS1",
+		"class/tty/ttyS2":   "devices/pnp0/00:01/00:01:0/00:01:0.0/tty/ttyS2",
+		"class/tty/tty0":    "devices/virtual/tty/tty0",
+
+		"devices/pci0000:00/usb1/1-1/1-1:1.0/ttyUSB0/tty/ttyUSB0/device": "devices/pci0000:00/usb1/1-1/1-1:1.0/ttyUSB0",
+		"devices/pci0000:00/usb1/1-1/1-1:1.0/ttyUSB0/driver":             "bus/usb-serial/drivers/ftdi_sio",
+		"devices/pci0000:00/usb1/1-3/1-3:1.0/ttyUSB1/tty/ttyUSB1/device": "devices/pci0000:00/usb1/1-3/1-3:1.0/ttyUSB1",
+		"devices/pci0000:00/usb1/1-3/1-3:1.0/ttyUSB1/driver":             "bus/usb-serial/drivers/ftdi_sio",
+		"devices/pci0000:00/usb1/1-2/1-2:1.2/tty/ttyACM0/device":         "devices/pci0000:00/usb1/1-2/1-2:1.2",
+		"devices/pci0000:00/usb1/1-2/1-2:1.2/driver":                     "bus/usb/drivers/cdc_acm",
+		"devices/platform/serial8250/tty/ttyS0/device":                   "devices/platform/serial8250",
+		"devices/platform/serial8250/tty/ttyS1/device":                   "devices/platform/serial8250",
+		"devices/platform/serial8250/driver":                             "bus/platform/drivers/serial8250",
+		"devices/pnp0/00:01/00:01:0/00:01:0.0/tty/ttyS2/device":          "devices/pnp0/00:01/00:01:0/00:01:0.0",
+		"devices/pnp0/00:01/00:01:0/00:01:0.0/subsystem":                 "bus/serial-base",
+		"devices/pnp0/00:01/00:01:0/subsystem":                           "bus/serial-base",
+		"devices/pnp0/00:01/driver":                                      "bus/pnp/drivers/serial",
+	}
+
+	for dir, attrs := range dirs {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("create fixture dir failed: %v", err)
+		}
+
+		for name, content := range attrs {
+			if err := ioutil.WriteFile(filepath.Join(root, dir, name), []byte(content), 0644); err != nil {
+				t.Fatalf("create fixture attr failed: %v", err)
+			}
+		}
+	}
+
+	for link, target := range links {
+		if err := os.Symlink(filepath.Join(root, target), filepath.Join(root, link)); err != nil {
+			t.Fatalf("create fixture link failed: %v", err)
+		}
+	}
+}
+
+func TestListPorts(t *testing.T) {
+	root, err := ioutil.TempDir("", "libserial-sysfs")
+	if err != nil {
+		t.Fatalf("create fixture root failed: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	makeSysfsFixture(t, root)
+
+	saved := sysfsRoot
+	sysfsRoot = root
+	defer func() {
+		sysfsRoot = saved
+	}()
+
+	ports, err := ListPorts()
+	if err != nil {
+		t.Fatalf("list ports failed: %v", err)
+	}
+
+	expected := []PortInfo{
+		{
+			Path: "/dev/ttyACM0", Name: "ttyACM0", Driver: "cdc_acm",
+			USB: true, VID: 0x2341, PID: 0x0043,
+			Manufacturer: "Arduino (www.arduino.cc)", Interface: 2, USBPath: "1-2",
+		},
+		{Path: "/dev/ttyS0", Name: "ttyS0", Driver: "serial8250"},
+		{Path: "/dev/ttyS2", Name: "ttyS2", Driver: "serial"},
+		{
+			Path: "/dev/ttyUSB0", Name: "ttyUSB0", Driver: "ftdi_sio",
+			USB: true, VID: 0x0403, PID: 0x6001, SerialNumber: "A50285BI",
+			Manufacturer: "FTDI", Product: "FT232R USB UART", Interface: 0, USBPath: "1-1",
+		},
+		{
+			Path: "/dev/ttyUSB1", Name: "ttyUSB1", Driver: "ftdi_sio",
+			USB: true, VID: 0x0403, PID: 0x6001, SerialNumber: "A9K3B2QX", USBPath: "1-3",
+		},
+	}
+
+	if !reflect.DeepEqual(ports, expected) {
+		t.Errorf("ports not match\ntarget: %+v\nresult: %+v", expected, ports)
+	}
+}
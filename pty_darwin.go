@@ -0,0 +1,61 @@
+// +build darwin
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY opens pty master and returns the path of its slave
+func openPTY() (*os.File, string, error) {
+	f, err := openPTYMaster()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// grantpt
+	if err = ioctl(f.Fd(), unix.TIOCPTYGRANT, 0); err != nil {
+		f.Close()
+		return nil, "", fmt.Errorf("fail to grant pty: %v", err)
+	}
+
+	// unlockpt
+	if err = ioctl(f.Fd(), unix.TIOCPTYUNLK, 0); err != nil {
+		f.Close()
+		return nil, "", fmt.Errorf("fail to unlock pty: %v", err)
+	}
+
+	// ptsname
+	name := make([]byte, 128)
+	if err = ioctl(f.Fd(), unix.TIOCPTYGNAME, uintptr(unsafe.Pointer(&name[0]))); err != nil {
+		f.Close()
+		return nil, "", fmt.Errorf("fail to get pty name: %v", err)
+	}
+
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+
+	return f, string(name), nil
+}
@@ -0,0 +1,52 @@
+// +build linux
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPTY opens pty master and returns the path of its slave
+func openPTY() (*os.File, string, error) {
+	f, err := openPTYMaster()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// unlockpt
+	unlock := int32(0)
+	if err = ioctl(f.Fd(), unix.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		f.Close()
+		return nil, "", fmt.Errorf("fail to unlock pty: %v", err)
+	}
+
+	// ptsname
+	n := uint32(0)
+	if err = ioctl(f.Fd(), unix.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		f.Close()
+		return nil, "", fmt.Errorf("fail to get pty number: %v", err)
+	}
+
+	return f, "/dev/pts/" + strconv.FormatUint(uint64(n), 10), nil
+}
@@ -0,0 +1,82 @@
+// +build linux darwin freebsd
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"io"
+	"os"
+)
+
+const ptyMasterDevice = "/dev/ptmx"
+
+// OpenPTYPair allocates two pseudo-terminals and links their master sides
+// (like `socat pty,raw,echo=0 pty,raw,echo=0`), then opens both slave sides
+// as SerialPort with the same options applied, data written to one port
+// can be read from the other one
+func OpenPTYPair(options ...Option) (a, b *SerialPort, err error) {
+	masterA, slaveA, err := openPTY()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	masterB, slaveB, err := openPTY()
+	if err != nil {
+		masterA.Close()
+		return nil, nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			masterA.Close()
+			masterB.Close()
+		}
+	}()
+
+	// slave sides must be opened before relaying,
+	// or reading from master side will fail with EIO
+	a, err = Open(slaveA, options...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b, err = Open(slaveB, options...)
+	if err != nil {
+		a.Close()
+		return nil, nil, err
+	}
+
+	go relayPTY(masterA, masterB)
+	go relayPTY(masterB, masterA)
+
+	return a, b, nil
+}
+
+// relayPTY copies data between pty master sides until either side fails,
+// which happens when all file descriptors of one slave side are closed
+func relayPTY(dst, src *os.File) {
+	io.Copy(dst, src)
+
+	src.Close()
+	dst.Close()
+}
+
+// openPTYMaster opens the pseudo-terminal master multiplexer
+func openPTYMaster() (*os.File, error) {
+	return os.OpenFile(ptyMasterDevice, serialFileFlag, 0)
+}
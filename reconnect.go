@@ -0,0 +1,322 @@
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// default backoff of reopening serial port
+const (
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 10 * time.Second
+)
+
+// ReconnectConfig of ReconnectingPort
+type ReconnectConfig struct {
+	// MinBackoff is the delay before the first reopening attempt,
+	// doubled after each failure until MaxBackoff,
+	// default is 100ms and 10s
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// SerialNumber of the usb adapter to verify after opening,
+	// not verified if empty, verification requires ListPorts
+	// (linux only), OpenReconnecting fails with ErrNotSupported otherwise
+	SerialNumber string
+
+	// OnConnect is called after the serial port (re)opened
+	OnConnect func()
+	// OnDisconnect is called with the error when the serial port disconnected
+	OnDisconnect func(err error)
+}
+
+// SerialNumberError happens when the usb serial number of the opened device
+// is not the one in ReconnectConfig
+type SerialNumberError struct {
+	// Expected is the serial number in ReconnectConfig
+	Expected string
+	// Actual is the serial number of the opened device
+	Actual string
+}
+
+func (e *SerialNumberError) Error() string {
+	return fmt.Sprintf("serial number not match: expected %q, actual %q", e.Expected, e.Actual)
+}
+
+// ReconnectingPort is a serial port reopened with the same options
+// automatically after disconnected (e.g. usb adapter unplugged),
+// Read and Write block while reopening
+type ReconnectingPort struct {
+	dev     string
+	options []Option
+	config  ReconnectConfig
+
+	// serialize reopening
+	reopenMu sync.Mutex
+
+	mu      sync.Mutex
+	port    *SerialPort
+	closed  bool
+	closeCh chan struct{}
+}
+
+// OpenReconnecting opens serial port which reopens automatically after disconnected,
+// it fails if the serial port can not be opened the first time
+func OpenReconnecting(device string, config ReconnectConfig, options ...Option) (*ReconnectingPort, error) {
+	if config.MinBackoff <= 0 {
+		config.MinBackoff = defaultMinBackoff
+	}
+
+	if config.MaxBackoff < config.MinBackoff {
+		config.MaxBackoff = defaultMaxBackoff
+		if config.MaxBackoff < config.MinBackoff {
+			config.MaxBackoff = config.MinBackoff
+		}
+	}
+
+	// serial number can not be verified without port enumeration
+	if config.SerialNumber != "" {
+		if _, err := ListPorts(); err == ErrNotSupported {
+			return nil, ErrNotSupported
+		}
+	}
+
+	r := &ReconnectingPort{
+		dev:     device,
+		options: options,
+		config:  config,
+		closeCh: make(chan struct{}),
+	}
+
+	p, err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	r.connected(p)
+
+	return r, nil
+}
+
+// Read bytes from serial port, it blocks while reopening
+func (r *ReconnectingPort) Read(data []byte) (int, error) {
+	for {
+		p, err := r.get()
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := p.Read(data)
+		if err != nil && r.isClosed() {
+			// closed while reading
+			return n, ErrPortClosed
+		}
+
+		// hung up tty returns zero bytes instead of error
+		disconnected := isDisconnectError(err) || err == io.EOF && p.hungUp()
+		if n > 0 || !disconnected {
+			return n, err
+		}
+
+		r.disconnected(p, err)
+	}
+}
+
+// Write bytes to serial port, it blocks while reopening,
+// bytes partially written before disconnected are not written again
+func (r *ReconnectingPort) Write(data []byte) (int, error) {
+	for {
+		p, err := r.get()
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := p.Write(data)
+		if err != nil && r.isClosed() {
+			// closed while writing
+			return n, ErrPortClosed
+		}
+
+		if !isDisconnectError(err) {
+			return n, err
+		}
+
+		r.disconnected(p, err)
+		if n > 0 {
+			return n, err
+		}
+	}
+}
+
+// Close serial port and stop reopening
+func (r *ReconnectingPort) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return ErrPortClosed
+	}
+
+	r.closed = true
+	close(r.closeCh)
+
+	if r.port == nil {
+		return nil
+	}
+
+	err := r.port.Close()
+	r.port = nil
+	return err
+}
+
+// Connected checks whether the serial port is opened currently
+func (r *ReconnectingPort) Connected() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.port != nil
+}
+
+// isClosed checks whether Close has been called
+func (r *ReconnectingPort) isClosed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.closed
+}
+
+// open serial port and verify the usb serial number
+func (r *ReconnectingPort) open() (*SerialPort, error) {
+	p, err := Open(r.dev, r.options...)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.config.SerialNumber != "" {
+		if err = verifySerialNumber(r.dev, r.config.SerialNumber); err != nil {
+			p.Close()
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// get returns current serial port, or reopens it with backoff if disconnected
+func (r *ReconnectingPort) get() (*SerialPort, error) {
+	r.reopenMu.Lock()
+	defer r.reopenMu.Unlock()
+
+	r.mu.Lock()
+	p, closed := r.port, r.closed
+	r.mu.Unlock()
+
+	if closed {
+		return nil, ErrPortClosed
+	}
+
+	if p != nil {
+		return p, nil
+	}
+
+	backoff := r.config.MinBackoff
+	for {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-r.closeCh:
+			timer.Stop()
+			return nil, ErrPortClosed
+		case <-timer.C:
+		}
+
+		p, err := r.open()
+		if err == nil {
+			if !r.connected(p) {
+				p.Close()
+				return nil, ErrPortClosed
+			}
+			return p, nil
+		}
+
+		if backoff *= 2; backoff > r.config.MaxBackoff {
+			backoff = r.config.MaxBackoff
+		}
+	}
+}
+
+// connected sets current serial port, false is returned if already closed
+func (r *ReconnectingPort) connected(p *SerialPort) bool {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return false
+	}
+	r.port = p
+	r.mu.Unlock()
+
+	if r.config.OnConnect != nil {
+		r.config.OnConnect()
+	}
+	return true
+}
+
+// disconnected closes the serial port p if it's still the current one
+func (r *ReconnectingPort) disconnected(p *SerialPort, err error) {
+	r.mu.Lock()
+	if r.port != p {
+		r.mu.Unlock()
+		return
+	}
+	r.port = nil
+	r.mu.Unlock()
+
+	p.Close()
+
+	if r.config.OnDisconnect != nil {
+		r.config.OnDisconnect(err)
+	}
+}
+
+// verifySerialNumber checks the usb serial number of the device
+func verifySerialNumber(dev, serialNumber string) error {
+	path, err := filepath.EvalSymlinks(dev)
+	if err != nil {
+		return err
+	}
+
+	ports, err := ListPorts()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range ports {
+		if p.Name != filepath.Base(path) {
+			continue
+		}
+
+		if p.SerialNumber != serialNumber {
+			return &SerialNumberError{Expected: serialNumber, Actual: p.SerialNumber}
+		}
+		return nil
+	}
+
+	return ErrPortNotFound
+}
@@ -0,0 +1,162 @@
+// +build linux
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReconnectingPort_SerialNumber(t *testing.T) {
+	root, err := ioutil.TempDir("", "libserial-sysfs")
+	if err != nil {
+		t.Fatalf("create fixture root failed: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	saved := sysfsRoot
+	sysfsRoot = root
+	defer func() {
+		sysfsRoot = saved
+	}()
+
+	link := filepath.Join(root, "ttyLINK")
+
+	// plug attaches the pty to an usb adapter with serial number in sysfs,
+	// and points the device link to it
+	plug := func(dev, serialNumber string) {
+		name := filepath.Base(dev)
+		usbDir := filepath.Join(root, "devices", "usb1", "1-"+name)
+		ifDir := filepath.Join(usbDir, "1-"+name+":1.0")
+		classDir := filepath.Join(root, "class", "tty", name)
+
+		for _, dir := range []string{ifDir, classDir} {
+			if err := os.RemoveAll(dir); err != nil {
+				t.Fatalf("remove fixture dir failed: %v", err)
+			}
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatalf("create fixture dir failed: %v", err)
+			}
+		}
+
+		attrs := map[string]string{
+			filepath.Join(usbDir, "idVendor"):        "0403\n",
+			filepath.Join(usbDir, "serial"):          serialNumber + "\n",
+			filepath.Join(ifDir, "bInterfaceNumber"): "00\n",
+		}
+		for path, content := range attrs {
+			if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatalf("create fixture attr failed: %v", err)
+			}
+		}
+
+		if err := os.Symlink(ifDir, filepath.Join(classDir, "device")); err != nil {
+			t.Fatalf("create fixture link failed: %v", err)
+		}
+
+		if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+			t.Fatalf("remove device link failed: %v", err)
+		}
+		if err := os.Symlink(dev, link); err != nil {
+			t.Fatalf("create device link failed: %v", err)
+		}
+	}
+
+	a, b := getSerialPort(t, baseOptions)
+	plug(a.dev, "A50285BI")
+
+	_, err = OpenReconnecting(link, ReconnectConfig{SerialNumber: "A9K3B2QX"}, baseOptions...)
+	if e, ok := err.(*SerialNumberError); !ok || e.Expected != "A9K3B2QX" || e.Actual != "A50285BI" {
+		t.Errorf("open port with other serial number should fail: err = %v", err)
+	}
+
+	connected := make(chan struct{}, 2)
+	disconnected := make(chan error, 1)
+	r, err := OpenReconnecting(link, ReconnectConfig{
+		MinBackoff:   20 * time.Millisecond,
+		MaxBackoff:   50 * time.Millisecond,
+		SerialNumber: "A50285BI",
+		OnConnect:    func() { connected <- struct{}{} },
+		OnDisconnect: func(err error) { disconnected <- err },
+	}, baseOptions...)
+	if err != nil {
+		t.Fatalf("open reconnecting port failed: %v", err)
+	}
+	defer r.Close()
+	<-connected
+
+	a.Close()
+	b.Close()
+
+	read := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, len(testRWData))
+		if _, err := io.ReadFull(r, buf); err != nil {
+			t.Errorf("read from reconnecting port failed: %v", err)
+		}
+		read <- buf
+	}()
+
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("disconnect not detected")
+	}
+
+	// plug in another adapter with the same path
+	c, d := getSerialPort(t, baseOptions)
+	defer func() {
+		c.Close()
+		d.Close()
+	}()
+	plug(c.dev, "A9K3B2QX")
+
+	select {
+	case <-connected:
+		t.Fatalf("port with other serial number reopened")
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	// plug in the adapter again
+	e, f := getSerialPort(t, baseOptions)
+	defer func() {
+		e.Close()
+		f.Close()
+	}()
+	plug(e.dev, "A50285BI")
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("port not reopened")
+	}
+
+	if _, err := f.Write(testRWData); err != nil {
+		t.Errorf("write data failed: %v", err)
+	}
+
+	if buf := <-read; !bytes.Equal(buf, testRWData) {
+		t.Errorf("read data not match: %v", string(buf))
+	}
+}
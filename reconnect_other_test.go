@@ -0,0 +1,30 @@
+// +build !linux
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"testing"
+)
+
+func TestOpenReconnecting_SerialNumberNotSupported(t *testing.T) {
+	_, err := OpenReconnecting("ttyNotExist", ReconnectConfig{SerialNumber: "A50285BI"})
+	if err != ErrNotSupported {
+		t.Errorf("serial number should not be supported: err = %v", err)
+	}
+}
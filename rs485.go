@@ -0,0 +1,140 @@
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"time"
+)
+
+// RS485Config is the config of RS-485 mode with direction (RTS) controlled
+// by the kernel driver
+type RS485Config struct {
+	// RTSOnSend set logical level of RTS when sending
+	RTSOnSend bool
+	// RTSAfterSend set logical level of RTS after sent
+	RTSAfterSend bool
+	// DelayBeforeSend is the delay of RTS before sending (ms precision)
+	DelayBeforeSend time.Duration
+	// DelayAfterSend is the delay of RTS after sent (ms precision)
+	DelayAfterSend time.Duration
+	// RxDuringTx keeps receiving while sending
+	RxDuringTx bool
+	// Termination enable bus termination if supported by the driver
+	Termination bool
+}
+
+// flags of struct serial_rs485
+const (
+	rs485Enabled      = 1 << 0
+	rs485RTSOnSend    = 1 << 1
+	rs485RTSAfterSend = 1 << 2
+	rs485RxDuringTx   = 1 << 4
+	rs485Termination  = 1 << 5
+)
+
+// serialRS485 is struct serial_rs485 in linux/serial.h
+type serialRS485 struct {
+	Flags              uint32
+	DelayRTSBeforeSend uint32
+	DelayRTSAfterSend  uint32
+	padding            [5]uint32
+}
+
+// WithRS485 enable RS-485 mode of the driver (TIOCSRS485),
+// the RTS line is toggled by the driver when sending
+// only supported on linux with drivers supporting RS-485
+func WithRS485(config RS485Config) Option {
+	return func(c *SerialPort) error {
+		if runtime.GOOS != "linux" {
+			return ErrNotSupported
+		}
+
+		for _, d := range []time.Duration{config.DelayBeforeSend, config.DelayAfterSend} {
+			if d < 0 || d/time.Millisecond > math.MaxUint32 {
+				return fmt.Errorf("invalid rs485 delay: %v", d)
+			}
+		}
+
+		c.rs485 = &config
+		c.disableRS485 = false
+		return nil
+	}
+}
+
+// WithoutRS485 disable RS-485 mode of the driver (TIOCSRS485),
+// it does nothing if the driver or platform doesn't support RS-485
+func WithoutRS485() Option {
+	return func(c *SerialPort) error {
+		c.rs485 = nil
+		c.disableRS485 = true
+		return nil
+	}
+}
+
+// RS485 returns RS-485 config of the driver (TIOCGRS485),
+// nil is returned if RS-485 mode is disabled
+func (s *SerialPort) RS485() (*RS485Config, error) {
+	return s.getRS485()
+}
+
+// encodeRS485 encodes config to struct serial_rs485, disabled if nil
+func encodeRS485(config *RS485Config) serialRS485 {
+	if config == nil {
+		return serialRS485{}
+	}
+
+	rs := serialRS485{
+		Flags: rs485Enabled,
+		// round up to milliseconds
+		DelayRTSBeforeSend: uint32((config.DelayBeforeSend + time.Millisecond - 1) / time.Millisecond),
+		DelayRTSAfterSend:  uint32((config.DelayAfterSend + time.Millisecond - 1) / time.Millisecond),
+	}
+
+	if config.RTSOnSend {
+		rs.Flags |= rs485RTSOnSend
+	}
+	if config.RTSAfterSend {
+		rs.Flags |= rs485RTSAfterSend
+	}
+	if config.RxDuringTx {
+		rs.Flags |= rs485RxDuringTx
+	}
+	if config.Termination {
+		rs.Flags |= rs485Termination
+	}
+
+	return rs
+}
+
+// decodeRS485 decodes config from struct serial_rs485, nil if disabled
+func decodeRS485(rs *serialRS485) *RS485Config {
+	if rs.Flags&rs485Enabled == 0 {
+		return nil
+	}
+
+	return &RS485Config{
+		RTSOnSend:       rs.Flags&rs485RTSOnSend != 0,
+		RTSAfterSend:    rs.Flags&rs485RTSAfterSend != 0,
+		DelayBeforeSend: time.Duration(rs.DelayRTSBeforeSend) * time.Millisecond,
+		DelayAfterSend:  time.Duration(rs.DelayRTSAfterSend) * time.Millisecond,
+		RxDuringTx:      rs.Flags&rs485RxDuringTx != 0,
+		Termination:     rs.Flags&rs485Termination != 0,
+	}
+}
@@ -0,0 +1,72 @@
+// +build linux
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// getRS485 returns RS-485 config of the driver, nil if disabled
+func (s *SerialPort) getRS485() (*RS485Config, error) {
+	return getRS485(s.fdIoctl)
+}
+
+// configureRS485 applies RS-485 config if set or disabled,
+// restore re-applies the config of the driver before
+func (s *SerialPort) configureRS485() (restore func(), err error) {
+	if s.rs485 == nil && !s.disableRS485 {
+		return func() {}, nil
+	}
+
+	saved := serialRS485{}
+	if err := s.fdIoctl(unix.TIOCGRS485, unsafe.Pointer(&saved)); err != nil {
+		if s.rs485 == nil && (err == unix.ENOTTY || err == unix.EINVAL) {
+			// RS-485 is never enabled without driver support
+			return func() {}, nil
+		}
+		return nil, fmt.Errorf("fail to get rs485 config: %v", err)
+	}
+
+	if err := setRS485(s.fdIoctl, s.rs485); err != nil {
+		return nil, err
+	}
+
+	return func() { s.fdIoctl(unix.TIOCSRS485, unsafe.Pointer(&saved)) }, nil
+}
+
+// setRS485 enable RS-485 mode with config (TIOCSRS485), disable if nil
+func setRS485(do ioctlFunc, config *RS485Config) error {
+	rs := encodeRS485(config)
+	if err := do(unix.TIOCSRS485, unsafe.Pointer(&rs)); err != nil {
+		return fmt.Errorf("fail to set rs485 config: %v", err)
+	}
+	return nil
+}
+
+// getRS485 returns RS-485 config (TIOCGRS485), nil if disabled
+func getRS485(do ioctlFunc) (*RS485Config, error) {
+	rs := serialRS485{}
+	if err := do(unix.TIOCGRS485, unsafe.Pointer(&rs)); err != nil {
+		return nil, fmt.Errorf("fail to get rs485 config: %v", err)
+	}
+	return decodeRS485(&rs), nil
+}
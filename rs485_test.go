@@ -0,0 +1,132 @@
+// +build linux
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRS485(t *testing.T) {
+	if size := unsafe.Sizeof(serialRS485{}); size != 32 {
+		t.Fatalf("size of serial_rs485 not correct: %v", size)
+	}
+
+	// fake driver keeping the struct set
+	var driver serialRS485
+	d := newFakeDriver()
+	d.handle(unix.TIOCSRS485, func(arg unsafe.Pointer) error {
+		driver = *(*serialRS485)(arg)
+		return nil
+	})
+	d.handle(unix.TIOCGRS485, func(arg unsafe.Pointer) error {
+		*(*serialRS485)(arg) = driver
+		return nil
+	})
+
+	if config, err := getRS485(d.ioctl); err != nil || config != nil {
+		t.Errorf("rs485 should be disabled: %+v, %v", config, err)
+	}
+
+	config := &RS485Config{
+		RTSOnSend:       true,
+		DelayBeforeSend: 2 * time.Millisecond,
+		DelayAfterSend:  1500 * time.Microsecond,
+		Termination:     true,
+	}
+	if err := setRS485(d.ioctl, config); err != nil {
+		t.Fatalf("set rs485 failed: %v", err)
+	}
+
+	expected := serialRS485{Flags: 0x23, DelayRTSBeforeSend: 2, DelayRTSAfterSend: 2}
+	if driver != expected {
+		t.Errorf("serial_rs485 not correct: %+v != %+v", driver, expected)
+	}
+
+	decoded, err := getRS485(d.ioctl)
+	if err != nil {
+		t.Fatalf("get rs485 failed: %v", err)
+	}
+
+	config.DelayAfterSend = 2 * time.Millisecond
+	if !reflect.DeepEqual(decoded, config) {
+		t.Errorf("rs485 config not correct: %+v != %+v", decoded, config)
+	}
+
+	// disabled with enable flag cleared
+	if err := setRS485(d.ioctl, nil); err != nil {
+		t.Fatalf("disable rs485 failed: %v", err)
+	}
+
+	if config, err := getRS485(d.ioctl); err != nil || config != nil || driver.Flags != 0 {
+		t.Errorf("rs485 should be disabled: %+v, %+v, %v", driver, config, err)
+	}
+
+	if err := WithRS485(RS485Config{DelayAfterSend: -1})(&SerialPort{}); err == nil {
+		t.Errorf("negative delay should be rejected")
+	}
+
+	p := &SerialPort{}
+	if err := WithRS485(*config)(p); err != nil || p.rs485 == nil || p.disableRS485 {
+		t.Errorf("rs485 should be enabled: %+v, %v, %v", p.rs485, p.disableRS485, err)
+	}
+
+	if err := WithoutRS485()(p); err != nil || p.rs485 != nil || !p.disableRS485 {
+		t.Errorf("rs485 should be disabled: %+v, %v, %v", p.rs485, p.disableRS485, err)
+	}
+}
+
+func TestSerialPort_ApplyRS485Failed(t *testing.T) {
+	r, w := getSerialPort(t, baseOptions)
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	// pty doesn't support RS-485, termios set before should be re-applied
+	if err := r.Apply(WithBaudRate(115200), WithRS485(RS485Config{RTSOnSend: true})); err == nil {
+		t.Fatalf("apply rs485 on pty should fail")
+	}
+
+	config, err := r.Config()
+	if err != nil {
+		t.Fatalf("get config failed: %v", err)
+	}
+
+	if config.BaudRate != 1200 || r.baudRate != 1200 {
+		t.Errorf("baud rate changed after failed apply: device = %v, options = %v", config.BaudRate, r.baudRate)
+	}
+}
+
+func TestSerialPort_WithoutRS485(t *testing.T) {
+	r, w := getSerialPort(t, baseOptions)
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	// pty doesn't support RS-485, which is never enabled
+	if err := r.Apply(WithoutRS485()); err != nil {
+		t.Errorf("disable rs485 on pty failed: %v", err)
+	}
+}
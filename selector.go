@@ -0,0 +1,184 @@
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrPortNotFound happens when no serial port matches the selector
+var ErrPortNotFound = errors.New("no serial port matches the selector")
+
+// serialByIDDir is where udev creates stable links of serial ports, replaced in tests
+var serialByIDDir = "/dev/serial/by-id"
+
+// PortSelector selects a serial port by stable hardware identity,
+// all non-zero fields must match
+type PortSelector struct {
+	// VID and PID of the usb adapter
+	VID uint16
+	PID uint16
+	// SerialNumber of the usb adapter
+	SerialNumber string
+	// ByID is the link name (or path) in /dev/serial/by-id
+	ByID string
+	// USBPath is the physical path of the usb device (e.g. 1-1.2),
+	// or of the usb interface (e.g. 1-1.2:1.0) for multi-port adapters
+	USBPath string
+}
+
+// String returns non-zero fields of the selector
+func (sel PortSelector) String() string {
+	var fields []string
+	if sel.VID != 0 || sel.PID != 0 {
+		fields = append(fields, fmt.Sprintf("%04x:%04x", sel.VID, sel.PID))
+	}
+
+	if sel.SerialNumber != "" {
+		fields = append(fields, "serial="+sel.SerialNumber)
+	}
+
+	if sel.ByID != "" {
+		fields = append(fields, "by-id="+sel.ByID)
+	}
+
+	if sel.USBPath != "" {
+		fields = append(fields, "usb-path="+sel.USBPath)
+	}
+
+	return strings.Join(fields, ",")
+}
+
+// MultiplePortsError happens when more than one serial port matches the selector
+type MultiplePortsError struct {
+	Selector PortSelector
+	Ports    []PortInfo
+}
+
+func (e *MultiplePortsError) Error() string {
+	paths := make([]string, len(e.Ports))
+	for i, p := range e.Ports {
+		paths[i] = p.Path
+	}
+
+	return fmt.Sprintf("multiple serial ports match the selector %v: %v", e.Selector, strings.Join(paths, ", "))
+}
+
+// FindPort returns the only serial port matches the selector,
+// ErrPortNotFound or MultiplePortsError is returned otherwise
+func FindPort(sel PortSelector) (PortInfo, error) {
+	if sel == (PortSelector{}) {
+		return PortInfo{}, fmt.Errorf("empty port selector")
+	}
+
+	ports, err := ListPorts()
+	if err != nil {
+		return PortInfo{}, err
+	}
+
+	// resolve by-id link to device name
+	byIDName := ""
+	if sel.ByID != "" {
+		link := sel.ByID
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(serialByIDDir, link)
+		}
+
+		target, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			return PortInfo{}, ErrPortNotFound
+		}
+		byIDName = filepath.Base(target)
+	}
+
+	var matches []PortInfo
+	for _, p := range ports {
+		if byIDName != "" && p.Name != byIDName {
+			continue
+		}
+
+		if sel.matchUSB(p) {
+			matches = append(matches, p)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return PortInfo{}, ErrPortNotFound
+	case 1:
+		return matches[0], nil
+	default:
+		return PortInfo{}, &MultiplePortsError{Selector: sel, Ports: matches}
+	}
+}
+
+// OpenPort opens the only serial port matches the selector
+func OpenPort(sel PortSelector, options ...Option) (*SerialPort, error) {
+	p, err := FindPort(sel)
+	if err != nil {
+		return nil, err
+	}
+
+	return Open(p.Path, options...)
+}
+
+// matchUSB checks usb related fields of the selector
+func (sel PortSelector) matchUSB(p PortInfo) bool {
+	if sel.VID == 0 && sel.PID == 0 && sel.SerialNumber == "" && sel.USBPath == "" {
+		return true
+	}
+
+	if !p.USB {
+		return false
+	}
+
+	if (sel.VID != 0 && sel.VID != p.VID) || (sel.PID != 0 && sel.PID != p.PID) {
+		return false
+	}
+
+	if sel.SerialNumber != "" && sel.SerialNumber != p.SerialNumber {
+		return false
+	}
+
+	if sel.USBPath == "" {
+		return true
+	}
+
+	// interface path is {usb path}:{config}.{interface}
+	devicePath, interfacePath := sel.USBPath, ""
+	if i := strings.IndexByte(sel.USBPath, ':'); i >= 0 {
+		devicePath, interfacePath = sel.USBPath[:i], sel.USBPath[i+1:]
+	}
+
+	if devicePath != p.USBPath {
+		return false
+	}
+
+	if interfacePath != "" {
+		i := strings.LastIndexByte(interfacePath, '.')
+		n, err := strconv.Atoi(interfacePath[i+1:])
+		if err != nil || n != p.Interface {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,89 @@
+// +build linux
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindPort(t *testing.T) {
+	root, err := ioutil.TempDir("", "libserial-sysfs")
+	if err != nil {
+		t.Fatalf("create fixture root failed: %v", err)
+	}
+	defer os.RemoveAll(root)
+
+	makeSysfsFixture(t, root)
+
+	// by-id link of ttyUSB0
+	byIDDir := filepath.Join(root, "dev", "serial", "by-id")
+	if err := os.MkdirAll(byIDDir, 0755); err != nil {
+		t.Fatalf("create by-id dir failed: %v", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(root, "dev", "ttyUSB0"), nil, 0644); err != nil {
+		t.Fatalf("create device failed: %v", err)
+	}
+
+	byID := "usb-FTDI_FT232R_USB_UART_A50285BI-if00-port0"
+	if err := os.Symlink("../../ttyUSB0", filepath.Join(byIDDir, byID)); err != nil {
+		t.Fatalf("create by-id link failed: %v", err)
+	}
+
+	savedRoot, savedByIDDir := sysfsRoot, serialByIDDir
+	sysfsRoot, serialByIDDir = root, byIDDir
+	defer func() {
+		sysfsRoot, serialByIDDir = savedRoot, savedByIDDir
+	}()
+
+	for _, c := range []struct {
+		sel  PortSelector
+		path string
+	}{
+		{PortSelector{VID: 0x0403, PID: 0x6001, SerialNumber: "A9K3B2QX"}, "/dev/ttyUSB1"},
+		{PortSelector{VID: 0x2341}, "/dev/ttyACM0"},
+		{PortSelector{ByID: byID}, "/dev/ttyUSB0"},
+		{PortSelector{ByID: filepath.Join(byIDDir, byID)}, "/dev/ttyUSB0"},
+		{PortSelector{USBPath: "1-3"}, "/dev/ttyUSB1"},
+		{PortSelector{USBPath: "1-2:1.2"}, "/dev/ttyACM0"},
+	} {
+		p, err := FindPort(c.sel)
+		if err != nil || p.Path != c.path {
+			t.Errorf("find port %v failed: target %v, result %v, %v", c.sel, c.path, p.Path, err)
+		}
+	}
+
+	for _, sel := range []PortSelector{
+		{VID: 0x0403, PID: 0x6001, SerialNumber: "00000000"},
+		{ByID: "usb-not-exists"},
+		{USBPath: "1-2:1.0"},
+	} {
+		if _, err := FindPort(sel); err != ErrPortNotFound {
+			t.Errorf("find port %v should fail with ErrPortNotFound: %v", sel, err)
+		}
+	}
+
+	_, err = FindPort(PortSelector{VID: 0x0403, PID: 0x6001})
+	if multiErr, ok := err.(*MultiplePortsError); !ok || len(multiErr.Ports) != 2 {
+		t.Errorf("find port with multiple matches should fail with MultiplePortsError: %v", err)
+	}
+}
@@ -17,31 +17,165 @@
 package libserial
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"runtime"
+	"sync"
 	"time"
 )
 
 var (
 	// ErrDeviceNameEmpty happens when opening a device with empty name
 	ErrDeviceNameEmpty = errors.New("device name should not be empty")
+	// ErrNotSupported happens when an operation is not available on current platform
+	ErrNotSupported = errors.New("operation not supported on this platform")
+	// ErrDrainTimeout happens when output can not be transmitted in time
+	ErrDrainTimeout = errors.New("drain serial port output timeout")
+	// ErrPortBusy happens when opening a serial port exclusively held by others
+	ErrPortBusy = errors.New("serial port is busy")
+	// ErrPortClosed happens when operating a closed serial port
+	ErrPortClosed = errors.New("serial port is closed")
+)
+
+// DefaultLockDir is the directory for UUCP lock files if not specified
+const DefaultLockDir = "/var/lock"
+
+// ApplyWhen defines when the options take effect
+type ApplyWhen int
+
+const (
+	// ApplyNow applies options immediately
+	ApplyNow ApplyWhen = iota
+	// ApplyAfterDrain applies options after all output written has been transmitted
+	ApplyAfterDrain
+	// ApplyAfterFlush applies options after all output written has been transmitted,
+	// and all input received but not read will be discarded
+	ApplyAfterFlush
+)
+
+// Config of serial port, decoded from the device settings
+type Config struct {
+	// BaudRate is the output baud rate
+	BaudRate      int
+	InputBaudRate int
+	DataBits      int
+	Parity        Parity
+	StopBits      StopBit
+
+	SoftwareFlowControl bool
+	HardwareFlowControl bool
+
+	// MarkLineErrors is true if line errors are marked in the input stream
+	MarkLineErrors bool
+
+	InputTranslation  InputTranslation
+	OutputTranslation OutputTranslation
+
+	// Canonical is nil when using raw mode
+	Canonical *CanonicalConfig
+
+	// ReadTimeout is zero when using blocking read
+	ReadTimeout time.Duration
+	// MinReadBytes and InterCharTimeout are zero when not set
+	MinReadBytes     int
+	InterCharTimeout time.Duration
+}
+
+// Options returns options to apply this config to SerialPort
+func (c Config) Options() []Option {
+	return []Option{
+		WithOutputBaudRate(c.BaudRate),
+		WithInputBaudRate(c.InputBaudRate),
+		WithDataBits(c.DataBits),
+		WithParity(c.Parity),
+		WithStopBits(c.StopBits),
+		WithSoftwareFlowControl(c.SoftwareFlowControl),
+		WithHardwareFlowControl(c.HardwareFlowControl),
+		WithLineErrorMarking(c.MarkLineErrors),
+		WithInputTranslation(c.InputTranslation),
+		WithOutputTranslation(c.OutputTranslation),
+		WithCanonicalMode(c.Canonical),
+		WithReadTimeout(c.ReadTimeout),
+		WithMinReadBytes(c.MinReadBytes),
+		WithInterCharTimeout(c.InterCharTimeout),
+	}
+}
+
+// flushDirection defines which queue to flush
+type flushDirection int
+
+const (
+	flushInput flushDirection = 1 << iota
+	flushOutput
+	flushInputOutput = flushInput | flushOutput
+)
+
+// queueSelector defines which queue to query
+type queueSelector int
+
+const (
+	inputQueue queueSelector = iota
+	outputQueue
 )
 
 // SerialPort of serial
 type SerialPort struct {
 	// actions performer
 	f     *os.File
-	flush func() error
+	flush func(d flushDirection) error
+	drain func() error
+
+	dev string
 
-	// options
+	// UUCP lock file created when opening
+	lockPath string
+
+	// deadlines set by SetDeadline, SetReadDeadline and SetWriteDeadline
+	deadlineMu    sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	// pending reads and writes canceled by ReadContext and WriteContext
+	pendingRead  pendingIO
+	pendingWrite pendingIO
+
+	// whether the file is managed by runtime poller (posix only)
+	pollable bool
+
+	// statistics counted by this library
+	counters portCounters
+
+	// pending calls of tcdrain and TIOCMIWAIT
+	pendingDrain pendingCall
+	modemWait    pendingCall
+
+	// options, guarded by optionsMu after opened
+	optionsMu sync.Mutex
+	portOptions
+}
+
+// portOptions are options set by Option
+type portOptions struct {
 	// common options
-	dev         string
-	readTimeout time.Duration
+	readTimeout      time.Duration
+	writeTimeout     time.Duration
+	minReadBytes     int
+	interCharTimeout time.Duration
+	applyWhen        ApplyWhen
+	inheritSettings  bool
+	exclusive        bool
+	lockFile         bool
+	lockDir          string
+
+	// baud rates, baudRate is also the output baud rate
+	baudRate      uint64
+	inputBaudRate uint64
 
 	// options for windows
-	baudRate uint64
 	dataBits byte
 	stopBits byte
 	parity   byte
@@ -49,26 +183,169 @@ type SerialPort struct {
 	// options for posix/linux
 	inputOptions   uint64
 	controlOptions uint64
+
+	// character translations, userspace emulated on windows
+	inputTranslation  InputTranslation
+	outputTranslation OutputTranslation
+
+	// canonical mode, nil for raw mode (posix only)
+	canonical *CanonicalConfig
+
+	// RS-485 mode of the driver (linux only),
+	// not changed if rs485 is nil and disableRS485 is false
+	rs485        *RS485Config
+	disableRS485 bool
+
+	// serial_struct options (linux only), nil if not set
+	lowLatency  *bool
+	closingWait *time.Duration
 }
 
-// Write bytes to serial connection
+// Write bytes to serial connection, it returns after all bytes written,
+// or WriteTimeoutError with the count of bytes written if timeout exceeded
 func (s *SerialPort) Write(data []byte) (int, error) {
-	return s.f.Write(data)
+	n, err := s.write(data)
+	s.countWrite(n, err)
+	return n, err
 }
 
 // Read bytes from serial connection
 func (s *SerialPort) Read(data []byte) (int, error) {
-	return s.f.Read(data)
+	n, err := s.read(data)
+	s.countRead(n, err)
+	if err == errReadTimeout {
+		// read timeout set by WithReadTimeout is reported as io.EOF
+		err = io.EOF
+	}
+	return n, err
 }
 
 // Close serial connection
 func (s *SerialPort) Close() error {
-	return s.f.Close()
+	return s.close()
 }
 
 // Flush serial input/output queue
 func (s *SerialPort) Flush() error {
-	return s.flush()
+	return s.flush(flushInputOutput)
+}
+
+// FlushInput discards data received but not read
+func (s *SerialPort) FlushInput() error {
+	return s.flush(flushInput)
+}
+
+// FlushOutput discards data written but not transmitted
+func (s *SerialPort) FlushOutput() error {
+	return s.flush(flushOutput)
+}
+
+// InputWaiting returns count of bytes received but not read
+func (s *SerialPort) InputWaiting() (int, error) {
+	return s.queueLength(inputQueue)
+}
+
+// OutputWaiting returns count of bytes written but not transmitted
+func (s *SerialPort) OutputWaiting() (int, error) {
+	return s.queueLength(outputQueue)
+}
+
+// Drain waits until all output written has been transmitted
+func (s *SerialPort) Drain() error {
+	return s.pendingDrain.call(context.Background(), s.drain)
+}
+
+// DrainTimeout waits until all output written has been transmitted,
+// ErrDrainTimeout is returned if it's not finished in timeout, the output
+// remains queued and the pending drain is reused by the next drain
+func (s *SerialPort) DrainTimeout(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := s.pendingDrain.call(ctx, s.drain); err != context.DeadlineExceeded {
+		return err
+	}
+	return ErrDrainTimeout
+}
+
+// CloseGracefully waits until all output written has been transmitted before closing,
+// if it's not finished in timeout, the remaining output is discarded and
+// ErrDrainTimeout is returned after serial port closed
+func (s *SerialPort) CloseGracefully(timeout time.Duration) error {
+	drainErr := s.DrainTimeout(timeout)
+	if drainErr == ErrDrainTimeout {
+		// discard remaining output, or closing may block
+		s.FlushOutput()
+	}
+
+	if err := s.Close(); err != nil {
+		return err
+	}
+
+	return drainErr
+}
+
+// SetBreak start (on) or stop (off) sending continuous break condition
+func (s *SerialPort) SetBreak(on bool) error {
+	return s.setBreak(on)
+}
+
+// SendBreak sends break condition for duration d
+func (s *SerialPort) SendBreak(d time.Duration) error {
+	return sendBreak(s.setBreak, d)
+}
+
+// sendBreak sets break condition with set, holds it for duration d and clears it
+func sendBreak(set func(on bool) error, d time.Duration) error {
+	if err := set(true); err != nil {
+		return err
+	}
+
+	time.Sleep(d)
+
+	return set(false)
+}
+
+// Config returns the effective config of serial port decoded from the device
+func (s *SerialPort) Config() (Config, error) {
+	return s.readConfig()
+}
+
+// Apply options to the opened serial port without closing it,
+// the port options remain unchanged if any of the options failed,
+// it's safe to call Apply while reading or writing
+//
+// use WithApplyWhen to choose when the options take effect for this call
+func (s *SerialPort) Apply(options ...Option) error {
+	s.optionsMu.Lock()
+	defer s.optionsMu.Unlock()
+
+	// apply when is only for this call
+	defer func() { s.applyWhen = ApplyNow }()
+
+	saved := s.portOptions
+
+	for _, setOption := range options {
+		if err := setOption(s); err != nil {
+			s.portOptions = saved
+			return err
+		}
+	}
+
+	if err := s.configure(); err != nil {
+		s.portOptions = saved
+		return err
+	}
+
+	return nil
+}
+
+// options returns a copy of port options for reading or writing,
+// so that options changed by Apply take effect on next Read or Write
+func (s *SerialPort) options() portOptions {
+	s.optionsMu.Lock()
+	defer s.optionsMu.Unlock()
+	return s.portOptions
 }
 
 // Open serial port
@@ -105,9 +382,28 @@ func Open(device string, options ...Option) (*SerialPort, error) {
 		return nil, err
 	}
 
+	// apply when is only for opening
+	port.applyWhen = ApplyNow
+
 	return port, nil
 }
 
+// inherit sets options from current device settings instead of configuring the device
+func (s *SerialPort) inherit() error {
+	c, err := s.readConfig()
+	if err != nil {
+		return err
+	}
+
+	for _, setOption := range c.Options() {
+		if err := setOption(s); err != nil {
+			return fmt.Errorf("fail to inherit serial port config: %v", err)
+		}
+	}
+
+	return nil
+}
+
 // Option for serial conn options
 type Option func(c *SerialPort) error
 
@@ -115,41 +411,172 @@ type Option func(c *SerialPort) error
 // if no read timeout set, use blocking read
 func WithReadTimeout(timeout time.Duration) Option {
 	return func(s *SerialPort) error {
-		if timeout > 0 {
-			s.readTimeout = timeout
+		if timeout < 0 {
+			timeout = 0
 		}
+		s.readTimeout = timeout
 		return nil
 	}
 }
 
-// WithBaudRate set serial baud rate
-// default is 9600
-func WithBaudRate(rate int) Option {
+// WithWriteTimeout set timeout timer for each Write call, WriteTimeoutError
+// is returned if not all bytes written in time (e.g. blocked by flow control)
+// if no write timeout set, use blocking write
+// Open and Apply fail with ErrNotSupported on posix if the device
+// does not support deadlines (not managed by runtime poller)
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(s *SerialPort) error {
+		if timeout < 0 {
+			timeout = 0
+		}
+		s.writeTimeout = timeout
+		return nil
+	}
+}
+
+// WithMinReadBytes set minimum bytes to read before Read returns (VMIN on posix)
+// available values are [0, 255], 0 means not set
+// it replaces read timeout in device settings, but read timeout still
+// limits the whole Read if deadlines are supported
+func WithMinReadBytes(n int) Option {
+	return func(s *SerialPort) error {
+		if n < 0 || n > math.MaxUint8 {
+			return fmt.Errorf("invalid min read bytes: %v", n)
+		}
+		s.minReadBytes = n
+		return nil
+	}
+}
+
+// WithInterCharTimeout set the max gap between bytes received, Read returns
+// once the gap exceeded after the first byte received (VTIME on posix,
+// with 100ms precision and max 25.5s), if min read bytes not set,
+// Read returns after the gap or the buffer filled (at most 255 bytes on posix)
+func WithInterCharTimeout(timeout time.Duration) Option {
+	return func(s *SerialPort) error {
+		if timeout < 0 {
+			timeout = 0
+		}
+		s.interCharTimeout = timeout
+		return nil
+	}
+}
+
+// WithInheritedSettings open serial port without changing the device settings,
+// all other options are replaced by the ones decoded from the device,
+// and only take effect when calling Apply
+func WithInheritedSettings(enable bool) Option {
+	return func(c *SerialPort) error {
+		c.inheritSettings = enable
+		return nil
+	}
+}
+
+// WithExclusive open serial port in exclusive mode, with an advisory lock
+// (flock) taken and TIOCEXCL set on posix, Open fails with ErrPortBusy
+// if the serial port is exclusively held by another one
+// com ports are always exclusive on windows
+// only takes effect when opening
+func WithExclusive(enable bool) Option {
+	return func(c *SerialPort) error {
+		c.exclusive = enable
+		return nil
+	}
+}
+
+// WithLockFile open serial port with UUCP lock file (LCK..<name>) created
+// in dir with the owning PID, like minicom and pppd do, Open fails with
+// ErrPortBusy if the lock file is owned by a living process,
+// stale lock file is removed, and the lock file is removed when closing
+// DefaultLockDir is used if dir is empty
+// only takes effect when opening, not supported on windows
+func WithLockFile(dir string) Option {
 	return func(c *SerialPort) error {
-		// do not check baud rate on windows
 		if runtime.GOOS == "windows" {
-			c.baudRate = uint64(rate)
-			return nil
+			return ErrNotSupported
+		}
+
+		c.lockFile = true
+		c.lockDir = dir
+		return nil
+	}
+}
+
+// WithApplyWhen set when the options take effect for the Open or Apply call
+// it's passed to, later calls take effect immediately unless set again
+// available values are {ApplyNow, ApplyAfterDrain, ApplyAfterFlush}
+// default is ApplyNow
+func WithApplyWhen(when ApplyWhen) Option {
+	return func(c *SerialPort) error {
+		switch when {
+		case ApplyNow, ApplyAfterDrain, ApplyAfterFlush:
+			c.applyWhen = when
+		default:
+			return fmt.Errorf("invalid apply when: %v", when)
 		}
+		return nil
+	}
+}
 
-		// check baud rate on non windows platform
-		baudRate, ok := validBaudRates[rate]
-		if !ok {
-			return fmt.Errorf("invalid baud rate: %v", rate)
+// WithBaudRate set serial baud rate for both input and output
+// default is 9600, non-standard baud rates are supported on linux and windows
+func WithBaudRate(rate int) Option {
+	return func(c *SerialPort) error {
+		if err := checkBaudRate(rate); err != nil {
+			return err
 		}
 
-		c.baudRate = uint64(baudRate)
+		c.baudRate = uint64(rate)
+		c.inputBaudRate = uint64(rate)
+		return nil
+	}
+}
 
-		// clear baud rate flags and set new baud rate
-		if runtime.GOOS == "linux" {
-			c.controlOptions &= ^uint64(maskBaudRate)
-			c.controlOptions |= uint64(baudRate)
+// WithInputBaudRate set serial baud rate for input only
+// not supported on windows unless it's the same as output baud rate
+func WithInputBaudRate(rate int) Option {
+	return func(c *SerialPort) error {
+		if err := checkBaudRate(rate); err != nil {
+			return err
 		}
 
+		c.inputBaudRate = uint64(rate)
 		return nil
 	}
 }
 
+// WithOutputBaudRate set serial baud rate for output only
+// not supported on windows unless it's the same as input baud rate
+func WithOutputBaudRate(rate int) Option {
+	return func(c *SerialPort) error {
+		if err := checkBaudRate(rate); err != nil {
+			return err
+		}
+
+		c.baudRate = uint64(rate)
+		return nil
+	}
+}
+
+func checkBaudRate(rate int) error {
+	if rate < 0 {
+		return fmt.Errorf("invalid baud rate: %v", rate)
+	}
+
+	// do not check baud rate on windows,
+	// and any baud rate can be set with termios2 on linux
+	if runtime.GOOS == "windows" || runtime.GOOS == "linux" {
+		return nil
+	}
+
+	// check baud rate on other platforms
+	if _, ok := validBaudRates[rate]; !ok {
+		return fmt.Errorf("invalid baud rate: %v", rate)
+	}
+
+	return nil
+}
+
 // WithDataBits set the data bits for SerialPort
 // available values are {5, 6, 7, 8}
 // default is 8
@@ -179,6 +606,8 @@ func WithDataBits(d int) Option {
 // WithParity set parity mode
 // available values are {ParityNone, ParityOdd, ParityEven}
 // default is ParityNone
+// ParityMark and ParitySpace are the same as ParityNone where they are
+// not supported (ParitySpace on linux, both on bsd)
 func WithParity(p Parity) Option {
 	return func(c *SerialPort) error {
 		c.parity = byte(p)
@@ -186,6 +615,11 @@ func WithParity(p Parity) Option {
 		// clear flags
 		c.controlOptions &= ^uint64(ParityOdd | ParityMark | parityEnable)
 
+		// ParityMark and ParitySpace may be zero, check ParityNone first
+		if p == ParityNone {
+			return nil
+		}
+
 		// keep ParityMark and ParitySpace separated for darwin compatibility
 		if p == ParityMark {
 			c.controlOptions |= uint64(p) | parityEnable
@@ -198,8 +632,6 @@ func WithParity(p Parity) Option {
 		}
 
 		switch p {
-		case ParityNone:
-			// do nothing default is None
 		case ParityOdd, ParityEven:
 			c.controlOptions |= uint64(p) | parityEnable
 		default:
@@ -263,3 +695,24 @@ func WithHardwareFlowControl(enable bool) Option {
 		return nil
 	}
 }
+
+// WithLineErrorMarking enable input parity checking (INPCK) and marking
+// of bytes with parity or framing error and break conditions (PARMRK),
+// use LineErrorReader to decode the marked input stream
+// not supported on windows
+func WithLineErrorMarking(enable bool) Option {
+	return func(c *SerialPort) error {
+		if enable && runtime.GOOS == "windows" {
+			return ErrNotSupported
+		}
+
+		// clear flags
+		c.inputOptions &= ^uint64(markErrorFlag)
+
+		if enable {
+			c.inputOptions |= uint64(markErrorFlag)
+		}
+
+		return nil
+	}
+}
@@ -18,19 +18,26 @@ package libserial
 
 import (
 	"bytes"
-	"flag"
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
 var (
-	inputPty    string
-	outputPty   string
-	readTimeout time.Duration
 	testRWData  = []byte("goiiot/libserial")
 	baseOptions = []Option{
+		WithBaudRate(1200),
 		WithDataBits(8),
 		WithParity(ParityNone),
 		WithHardwareFlowControl(false),
@@ -38,34 +45,14 @@ var (
 	}
 )
 
-func init() {
-	flag.StringVar(&inputPty, "i", "", "input pty file path")
-	flag.StringVar(&outputPty, "o", "", "output pty file path")
-	flag.DurationVar(&readTimeout, "t", 0, "read timeout")
-	baud := flag.Int("b", 0, "")
-
-	flag.Parse()
-
-	if inputPty == "" {
-		panic("input pty is nil")
-	}
-
-	if outputPty == "" {
-		panic("output pty is nil")
-	}
-
-	baseOptions = append(baseOptions, WithBaudRate(*baud))
-}
-
-func getSerialPort(options []Option) (reader, writer *SerialPort) {
-	w, err := Open(inputPty, options...)
-	if err != nil {
-		panic(fmt.Sprintf("fatal err: %v", err))
+func getSerialPort(t *testing.T, options []Option) (reader, writer *SerialPort) {
+	r, w, err := OpenPTYPair(options...)
+	if err == ErrNotSupported {
+		t.Skipf("pty pair not available: %v", err)
 	}
 
-	r, err := Open(outputPty, options...)
 	if err != nil {
-		panic(fmt.Sprintf("fatal err: %v", err))
+		t.Fatalf("fatal err: %v", err)
 	}
 
 	return r, w
@@ -73,7 +60,7 @@ func getSerialPort(options []Option) (reader, writer *SerialPort) {
 
 func TestSerialPort_ReadTimeout(t *testing.T) {
 	options := append([]Option{WithReadTimeout(2 * time.Second)}, baseOptions...)
-	r, w := getSerialPort(options)
+	r, w := getSerialPort(t, options)
 	defer func() {
 		r.Close()
 		w.Close()
@@ -90,8 +77,206 @@ func TestSerialPort_ReadTimeout(t *testing.T) {
 	}
 }
 
+func TestSerialPort_ReadDeadline(t *testing.T) {
+	options := append([]Option{WithReadTimeout(10 * time.Second)}, baseOptions...)
+	r, w := getSerialPort(t, options)
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	// read deadline takes precedence over read timeout
+	if err := r.SetReadDeadline(time.Now().Add(500 * time.Millisecond)); err != nil {
+		t.Fatalf("set read deadline failed: %v", err)
+	}
+
+	start := time.Now()
+	_, err := r.Read(make([]byte, 128))
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("read deadline failed: err = %v", err)
+	}
+
+	if duration := time.Now().Sub(start); duration > 5*time.Second {
+		t.Errorf("read deadline not correct: %v", duration)
+	}
+
+	// clear deadline and read again
+	if err := r.SetReadDeadline(time.Time{}); err != nil {
+		t.Errorf("clear read deadline failed: %v", err)
+	}
+
+	if _, err := w.Write(testRWData); err != nil {
+		t.Errorf("write data failed: %v", err)
+	}
+
+	buf := make([]byte, len(testRWData))
+	if _, err := io.ReadFull(r, buf); err != nil || !bytes.Equal(buf, testRWData) {
+		t.Errorf("read after clearing deadline failed: %v, %v", string(buf), err)
+	}
+}
+
+func TestSerialPort_ReadContext(t *testing.T) {
+	r, w := getSerialPort(t, baseOptions)
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(500*time.Millisecond, cancel)
+
+	if _, err := r.ReadContext(ctx, make([]byte, 128)); err != context.Canceled {
+		t.Errorf("read context not canceled: err = %v", err)
+	}
+
+	if _, err := w.Write(testRWData); err != nil {
+		t.Errorf("write data failed: %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	buf := make([]byte, len(testRWData))
+	if n, err := r.ReadContext(ctx, buf); err != nil || !bytes.Equal(buf[:n], testRWData) {
+		t.Errorf("read context failed: %v, %v", string(buf[:n]), err)
+	}
+}
+
+func TestSerialPort_ReadContextDeadline(t *testing.T) {
+	r, w := getSerialPort(t, baseOptions)
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	readDeadline := func() time.Time {
+		r.deadlineMu.Lock()
+		defer r.deadlineMu.Unlock()
+		return r.readDeadline
+	}
+
+	// deadline before the op is restored
+	saved := time.Now().Add(time.Hour)
+	if err := r.SetReadDeadline(saved); err != nil {
+		t.Fatalf("set read deadline failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(500*time.Millisecond, cancel)
+	if _, err := r.ReadContext(ctx, make([]byte, 128)); err != context.Canceled {
+		t.Errorf("read context not canceled: err = %v", err)
+	}
+
+	if d := readDeadline(); !d.Equal(saved) {
+		t.Errorf("read deadline not restored: %v, want %v", d, saved)
+	}
+
+	// deadline set during the op is kept, with or without ctx canceled
+	for _, canceled := range []bool{true, false} {
+		set := time.Now().Add(2 * time.Hour)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		time.AfterFunc(200*time.Millisecond, func() {
+			r.SetReadDeadline(set)
+			if canceled {
+				cancel()
+			} else {
+				w.Write(testRWData)
+			}
+		})
+
+		_, err := r.ReadContext(ctx, make([]byte, 128))
+		if canceled && err != context.Canceled || !canceled && err != nil {
+			t.Errorf("read context failed: canceled = %v, err = %v", canceled, err)
+		}
+		cancel()
+
+		if d := readDeadline(); !d.Equal(set) {
+			t.Errorf("read deadline set during op overwritten: canceled = %v, got %v, want %v", canceled, d, set)
+		}
+	}
+}
+
+func TestSerialPort_MinReadBytes(t *testing.T) {
+	options := append([]Option{WithMinReadBytes(len(testRWData))}, baseOptions...)
+	r, w := getSerialPort(t, options)
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	half := len(testRWData) / 2
+	go func() {
+		w.Write(testRWData[:half])
+		time.Sleep(500 * time.Millisecond)
+		w.Write(testRWData[half:])
+	}()
+
+	buf := make([]byte, 128)
+	if n, err := r.Read(buf); err != nil || !bytes.Equal(buf[:n], testRWData) {
+		t.Errorf("read min bytes failed: %v, %v", string(buf[:n]), err)
+	}
+}
+
+func TestSerialPort_InterCharTimeout(t *testing.T) {
+	options := append([]Option{
+		WithMinReadBytes(len(testRWData) * 2),
+		WithInterCharTimeout(500 * time.Millisecond),
+	}, baseOptions...)
+	r, w := getSerialPort(t, options)
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	c, err := r.Config()
+	if err != nil {
+		t.Fatalf("get config failed: %v", err)
+	}
+
+	if c.MinReadBytes != len(testRWData)*2 || c.InterCharTimeout != 500*time.Millisecond {
+		t.Errorf("config not match: min = %v, gap = %v", c.MinReadBytes, c.InterCharTimeout)
+	}
+
+	if _, err := w.Write(testRWData); err != nil {
+		t.Errorf("write data failed: %v", err)
+	}
+
+	// return less than min read bytes after gap
+	start := time.Now()
+	buf := make([]byte, 128)
+	if n, err := r.Read(buf); err != nil || !bytes.Equal(buf[:n], testRWData) {
+		t.Errorf("read with inter-char timeout failed: %v, %v", string(buf[:n]), err)
+	}
+
+	if duration := time.Now().Sub(start); duration < 400*time.Millisecond {
+		t.Errorf("inter-char timeout not correct: %v", duration)
+	}
+}
+
+func TestSerialPort_WriteTimeout(t *testing.T) {
+	options := append([]Option{WithWriteTimeout(500 * time.Millisecond)}, baseOptions...)
+	r, w := getSerialPort(t, options)
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	// nothing is read from r, write will be blocked once all buffers are full
+	data := make([]byte, 4<<20)
+	n, err := w.Write(data)
+
+	timeoutErr, ok := err.(*WriteTimeoutError)
+	if !ok || !timeoutErr.Timeout() {
+		t.Fatalf("write timeout failed: n = %v, err = %v", n, err)
+	}
+
+	if n == 0 || n == len(data) || timeoutErr.Written != n {
+		t.Errorf("write timeout bytes written not correct: n = %v, written = %v", n, timeoutErr.Written)
+	}
+}
+
 func TestSerialPort_ReadWrite(t *testing.T) {
-	r, w := getSerialPort(baseOptions)
+	r, w := getSerialPort(t, baseOptions)
 	defer func() {
 		r.Close()
 		w.Close()
@@ -114,9 +299,44 @@ func TestSerialPort_ReadWrite(t *testing.T) {
 	}
 }
 
+func TestSerialPort_ModemLines(t *testing.T) {
+	r, w := getSerialPort(t, baseOptions)
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	// ptys may not support modem lines, the calls either succeed
+	// or return the error from the driver
+	checkErr := func(name string, err error) {
+		if _, ok := err.(syscall.Errno); err != nil && !ok {
+			t.Errorf("%s error not clean: %#v", name, err)
+		}
+	}
+
+	checkErr("set dtr", w.SetDTR(true))
+	checkErr("set rts", w.SetRTS(false))
+	_, err := w.ModemStatus()
+	checkErr("modem status", err)
+
+	// port remains usable
+	if _, err = w.Write(testRWData); err != nil {
+		t.Errorf("write data failed: %v", err)
+	}
+
+	buf := make([]byte, len(testRWData))
+	if _, err = io.ReadFull(r, buf); err != nil {
+		t.Errorf("read data failed: %v", err)
+	}
+
+	if !bytes.Equal(testRWData, buf) {
+		t.Errorf("target: %v, result: %v", testRWData, buf)
+	}
+}
+
 func TestSerialPort_Flush(t *testing.T) {
 	options := append([]Option{WithReadTimeout(time.Second)}, baseOptions...)
-	r, w := getSerialPort(options)
+	r, w := getSerialPort(t, options)
 	defer func() {
 		r.Close()
 		w.Close()
@@ -139,3 +359,712 @@ func TestSerialPort_Flush(t *testing.T) {
 		t.Errorf("flush port failed, data still there: %v", string(buf[:n]))
 	}
 }
+
+func TestSerialPort_FlushInputOutput(t *testing.T) {
+	options := append([]Option{WithReadTimeout(time.Second)}, baseOptions...)
+	r, w := getSerialPort(t, options)
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	if _, err := w.Write(testRWData); err != nil {
+		t.Errorf("write to pty faild: %v", err)
+	}
+
+	time.Sleep(time.Second)
+
+	// flushing output should keep received data
+	if err := r.FlushOutput(); err != nil {
+		t.Errorf("flush port output failed: %v", err)
+	}
+
+	buf := make([]byte, 128)
+	if n, err := r.Read(buf); err != nil || !bytes.Equal(buf[:n], testRWData) {
+		t.Errorf("data lost after flushing output: %v, %v", string(buf[:n]), err)
+	}
+
+	if _, err := w.Write(testRWData); err != nil {
+		t.Errorf("write to pty faild: %v", err)
+	}
+
+	time.Sleep(time.Second)
+
+	if err := r.FlushInput(); err != nil {
+		t.Errorf("flush port input failed: %v", err)
+	}
+
+	if n, err := r.Read(buf); err == nil {
+		t.Errorf("flush port input failed, data still there: %v", string(buf[:n]))
+	}
+}
+
+func TestSerialPort_Waiting(t *testing.T) {
+	r, w := getSerialPort(t, baseOptions)
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	if n, err := r.InputWaiting(); err != nil || n != 0 {
+		t.Errorf("input waiting not correct: n = %v, err = %v", n, err)
+	}
+
+	if _, err := w.Write(testRWData); err != nil {
+		t.Errorf("write to pty faild: %v", err)
+	}
+
+	time.Sleep(time.Second)
+
+	if n, err := r.InputWaiting(); err != nil || n != len(testRWData) {
+		t.Errorf("input waiting not correct: n = %v, err = %v", n, err)
+	}
+
+	// output has been transmitted to the other side
+	if n, err := w.OutputWaiting(); err != nil || n != 0 {
+		t.Errorf("output waiting not correct: n = %v, err = %v", n, err)
+	}
+
+	buf := make([]byte, len(testRWData))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Errorf("read data failed: %v", err)
+	}
+
+	if n, err := r.InputWaiting(); err != nil || n != 0 {
+		t.Errorf("input waiting not correct after read: n = %v, err = %v", n, err)
+	}
+}
+
+func TestSerialPort_Exclusive(t *testing.T) {
+	options := append([]Option{WithExclusive(true)}, baseOptions...)
+	r, w := getSerialPort(t, options)
+	defer w.Close()
+
+	if _, err := Open(r.dev, options...); err != ErrPortBusy {
+		t.Errorf("open exclusive port again should fail with ErrPortBusy: %v", err)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Errorf("close port failed: %v", err)
+	}
+
+	p, err := Open(r.dev, options...)
+	if err != nil {
+		t.Fatalf("open port after closed failed: %v", err)
+	}
+	p.Close()
+}
+
+func TestSerialPort_LockFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "libserial")
+	if err != nil {
+		t.Fatalf("create lock dir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := append([]Option{WithLockFile(dir)}, baseOptions...)
+	r, w := getSerialPort(t, options)
+	defer w.Close()
+
+	lockPath := filepath.Join(dir, "LCK.."+filepath.Base(r.dev))
+	if data, err := ioutil.ReadFile(lockPath); err != nil || string(data) != fmt.Sprintf("%10d\n", os.Getpid()) {
+		t.Errorf("lock file content not correct: %q, %v", data, err)
+	}
+
+	if _, err := Open(r.dev, options...); err != ErrPortBusy {
+		t.Errorf("open locked port should fail with ErrPortBusy: %v", err)
+	}
+
+	// symlink shares the lock with the device
+	link := filepath.Join(dir, "by-id")
+	if err := os.Symlink(r.dev, link); err != nil {
+		t.Fatalf("create symlink failed: %v", err)
+	}
+
+	if _, err := Open(link, options...); err != ErrPortBusy {
+		t.Errorf("open locked port via symlink should fail with ErrPortBusy: %v", err)
+	}
+
+	// replace lock file of w with one of a dead process
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("run command failed: %v", err)
+	}
+
+	stale := fmt.Sprintf("%10d\n", cmd.Process.Pid)
+	staleLockPath := filepath.Join(dir, "LCK.."+filepath.Base(w.dev))
+	if err := ioutil.WriteFile(staleLockPath, []byte(stale), 0644); err != nil {
+		t.Fatalf("write stale lock file failed: %v", err)
+	}
+
+	p, err := Open(w.dev, options...)
+	if err != nil {
+		t.Fatalf("open port with stale lock file failed: %v", err)
+	}
+	p.Close()
+
+	if err := r.Close(); err != nil {
+		t.Errorf("close port failed: %v", err)
+	}
+
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Errorf("lock file not removed after closed: %v", err)
+	}
+}
+
+func TestReconnectingPort(t *testing.T) {
+	a, b := getSerialPort(t, baseOptions)
+
+	dir, err := ioutil.TempDir("", "libserial")
+	if err != nil {
+		t.Fatalf("create temp dir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	link := filepath.Join(dir, "ttyLINK")
+	if err := os.Symlink(a.dev, link); err != nil {
+		t.Fatalf("create device link failed: %v", err)
+	}
+
+	connected := make(chan struct{}, 2)
+	disconnected := make(chan error, 1)
+	r, err := OpenReconnecting(link, ReconnectConfig{
+		MinBackoff:   50 * time.Millisecond,
+		MaxBackoff:   200 * time.Millisecond,
+		OnConnect:    func() { connected <- struct{}{} },
+		OnDisconnect: func(err error) { disconnected <- err },
+	}, baseOptions...)
+	if err != nil {
+		t.Fatalf("open reconnecting port failed: %v", err)
+	}
+	defer r.Close()
+	<-connected
+
+	// closing all file descriptors of one side hangs up the other side
+	a.Close()
+	b.Close()
+
+	read := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, len(testRWData))
+		if _, err := io.ReadFull(r, buf); err != nil {
+			t.Errorf("read from reconnecting port failed: %v", err)
+		}
+		read <- buf
+	}()
+
+	select {
+	case err := <-disconnected:
+		if err == nil {
+			t.Errorf("disconnect error not reported")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("disconnect not detected")
+	}
+
+	// plug in another device with the same path
+	a, b = getSerialPort(t, baseOptions)
+	defer func() {
+		a.Close()
+		b.Close()
+	}()
+
+	if err := os.Remove(link); err != nil {
+		t.Fatalf("remove device link failed: %v", err)
+	}
+
+	if err := os.Symlink(a.dev, link); err != nil {
+		t.Fatalf("create device link failed: %v", err)
+	}
+
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("port not reopened")
+	}
+
+	if _, err := b.Write(testRWData); err != nil {
+		t.Errorf("write data failed: %v", err)
+	}
+
+	if buf := <-read; !bytes.Equal(buf, testRWData) {
+		t.Errorf("read data not match: %v", string(buf))
+	}
+
+	if err := r.Close(); err != nil {
+		t.Errorf("close reconnecting port failed: %v", err)
+	}
+
+	if _, err := r.Read(make([]byte, 1)); err != ErrPortClosed {
+		t.Errorf("read closed port should fail with ErrPortClosed: %v", err)
+	}
+}
+
+func TestReconnectingPort_CloseWhileReading(t *testing.T) {
+	a, b := getSerialPort(t, baseOptions)
+	defer func() {
+		a.Close()
+		b.Close()
+	}()
+
+	r, err := OpenReconnecting(a.dev, ReconnectConfig{}, baseOptions...)
+	if err != nil {
+		t.Fatalf("open reconnecting port failed: %v", err)
+	}
+
+	read := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		read <- err
+	}()
+
+	// wait for read blocking
+	time.Sleep(100 * time.Millisecond)
+
+	if err := r.Close(); err != nil {
+		t.Errorf("close reconnecting port failed: %v", err)
+	}
+
+	select {
+	case err := <-read:
+		if err != ErrPortClosed {
+			t.Errorf("read should fail with ErrPortClosed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("read not returned after close")
+	}
+}
+
+func TestSerialPort_Apply(t *testing.T) {
+	r, w := getSerialPort(t, baseOptions)
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	// invalid options should not change current settings
+	if err := r.Apply(WithReadTimeout(time.Second), WithDataBits(9)); err == nil {
+		t.Errorf("apply invalid data bits should fail")
+	}
+
+	if r.readTimeout != 0 || r.dataBits != 8 {
+		t.Errorf("options changed after failed apply: timeout = %v, data bits = %v", r.readTimeout, r.dataBits)
+	}
+
+	// switch from blocking read to read with timeout
+	if err := r.Apply(WithReadTimeout(time.Second), WithApplyWhen(ApplyAfterFlush)); err != nil {
+		t.Fatalf("apply read timeout failed: %v", err)
+	}
+
+	// apply when is only for the call
+	if r.applyWhen != ApplyNow {
+		t.Errorf("apply when should be reset after apply: %v", r.applyWhen)
+	}
+
+	start := time.Now()
+	i, err := r.Read(make([]byte, 128))
+	if err != nil && err != io.EOF || i != 0 {
+		t.Errorf("read timeout failed: err = %v, i = %v", err, i)
+	}
+
+	if duration := time.Now().Sub(start); duration < 500*time.Millisecond {
+		t.Errorf("read timeout not applied")
+	}
+}
+
+func TestSerialPort_ApplyConcurrently(t *testing.T) {
+	options := append([]Option{WithReadTimeout(10 * time.Millisecond)}, baseOptions...)
+	r, w := getSerialPort(t, options)
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		buf := make([]byte, 128)
+		for i := 0; i < 50; i++ {
+			r.Read(buf)
+			w.Write(testRWData)
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		timeout := time.Duration(i%5+1) * 10 * time.Millisecond
+		if err := r.Apply(WithReadTimeout(timeout), WithInterCharTimeout(timeout)); err != nil {
+			t.Errorf("apply while reading failed: %v", err)
+		}
+		if err := w.Apply(WithWriteTimeout(time.Second)); err != nil {
+			t.Errorf("apply while writing failed: %v", err)
+		}
+	}
+
+	<-done
+}
+
+func TestSerialPort_Config(t *testing.T) {
+	// data bits and parity are fixed to 8N for pty on linux
+	options := append([]Option{
+		WithStopBits(StopBitTwo),
+		WithReadTimeout(time.Second),
+	}, baseOptions...)
+	options = append(options, WithSoftwareFlowControl(true))
+	r, w := getSerialPort(t, options)
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	target := Config{
+		BaudRate:      1200,
+		InputBaudRate: 1200,
+		DataBits:      8,
+		Parity:        ParityNone,
+		StopBits:      StopBitTwo,
+		ReadTimeout:   time.Second,
+
+		SoftwareFlowControl: true,
+	}
+
+	c, err := r.Config()
+	if err != nil {
+		t.Fatalf("get config failed: %v", err)
+	}
+
+	if c != target {
+		t.Errorf("target: %+v, result: %+v", target, c)
+	}
+
+	// open again without changing settings
+	p, err := Open(r.dev, WithInheritedSettings(true), WithBaudRate(9600))
+	if err != nil {
+		t.Fatalf("open with inherited settings failed: %v", err)
+	}
+	defer p.Close()
+
+	if c, err = p.Config(); err != nil || c != target {
+		t.Errorf("target: %+v, result: %+v, err: %v", target, c, err)
+	}
+
+	if p.baudRate != 1200 || p.readTimeout != time.Second || p.inputOptions == 0 {
+		t.Errorf("options not inherited: %+v", p.portOptions)
+	}
+}
+
+func TestConfig_Options(t *testing.T) {
+	for _, options := range [][]Option{
+		{WithReadTimeout(time.Second)},
+		{WithInterCharTimeout(200 * time.Millisecond)},
+		{WithMinReadBytes(16)},
+		{WithMinReadBytes(16), WithInterCharTimeout(200 * time.Millisecond), WithReadTimeout(time.Second)},
+		{WithCanonicalMode(&CanonicalConfig{EOL: '\r'}), WithInputTranslation(InputIgnoreCR)},
+	} {
+		r, w := getSerialPort(t, append(options, baseOptions...))
+
+		c, err := r.Config()
+		if err != nil {
+			t.Fatalf("get config failed: %v", err)
+		}
+
+		// apply the config to another port
+		if err = w.Apply(c.Options()...); err != nil {
+			t.Errorf("apply config failed: %v", err)
+		}
+
+		if applied, err := w.Config(); err != nil || !reflect.DeepEqual(applied, c) {
+			t.Errorf("config not round-tripped: %+v != %+v, err = %v", applied, c, err)
+		}
+
+		r.Close()
+		w.Close()
+	}
+
+	r, w := getSerialPort(t, append([]Option{WithInterCharTimeout(200 * time.Millisecond)}, baseOptions...))
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	if c, err := r.Config(); err != nil || c.MinReadBytes != 0 || c.InterCharTimeout != 200*time.Millisecond {
+		t.Errorf("inter-character timeout config not correct: %+v, err = %v", c, err)
+	}
+}
+
+func TestSerialPort_CloseGracefully(t *testing.T) {
+	r, w := getSerialPort(t, baseOptions)
+	defer r.Close()
+
+	if _, err := w.Write(testRWData); err != nil {
+		t.Errorf("write data failed: %v", err)
+	}
+
+	if err := w.DrainTimeout(time.Second); err != nil {
+		t.Errorf("drain output failed: %v", err)
+	}
+
+	if _, err := w.Write(testRWData); err != nil {
+		t.Errorf("write data failed: %v", err)
+	}
+
+	if err := w.CloseGracefully(time.Second); err != nil {
+		t.Errorf("close gracefully failed: %v", err)
+	}
+}
+
+func TestLineErrorReader(t *testing.T) {
+	input := []byte{'a', 0xff, 0xff, 'b', 0xff, 0x00, 'c', 'd', 0xff, 0x00, 0x00, 'e', 0xff, 'f'}
+	expectedData := []byte{'a', 0xff, 'b', 'd', 'e', 0xff, 'f'}
+	expectedErrors := []LineError{
+		{Type: LineErrorParity, Offset: 3, Byte: 'c', Count: 1},
+		{Type: LineErrorBreak, Offset: 4, Count: 1},
+	}
+
+	readers := map[string]io.Reader{
+		"Whole":   bytes.NewReader(input),
+		"OneByte": iotest.OneByteReader(bytes.NewReader(input)),
+	}
+
+	for name, r := range readers {
+		t.Run(name, func(t *testing.T) {
+			d := NewLineErrorReader(r)
+			data, err := ioutil.ReadAll(d)
+			if err != nil {
+				t.Fatalf("read failed: %v", err)
+			}
+
+			if !bytes.Equal(data, expectedData) {
+				t.Errorf("data not match: %q != %q", data, expectedData)
+			}
+
+			if errs := d.Errors(); !reflect.DeepEqual(errs, expectedErrors) {
+				t.Errorf("errors not match: %v != %v", errs, expectedErrors)
+			}
+
+			if errs := d.Errors(); len(errs) != 0 {
+				t.Errorf("errors not cleared: %v", errs)
+			}
+		})
+	}
+
+	t.Run("Counts", func(t *testing.T) {
+		d := NewLineErrorReader(bytes.NewReader(input))
+		d.counts = func() (lineErrorCounts, error) {
+			return lineErrorCounts{framing: 1, parity: 1, overrun: 2}, nil
+		}
+
+		if _, err := ioutil.ReadAll(d); err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+
+		expected := []LineError{
+			{Type: LineErrorFraming, Offset: 3, Byte: 'c', Count: 1},
+			{Type: LineErrorParity, Offset: 4, Count: 1},
+			{Type: LineErrorOverrun, Offset: 7, Count: 2},
+		}
+		if errs := d.Errors(); !reflect.DeepEqual(errs, expected) {
+			t.Errorf("errors not match: %v != %v", errs, expected)
+		}
+	})
+}
+
+func TestSerialPort_Counters(t *testing.T) {
+	options := append([]Option{WithReadTimeout(500 * time.Millisecond)}, baseOptions...)
+	r, w := getSerialPort(t, options)
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	// driver counters are not available on pty, library counters still returned
+	prev, err := r.Counters()
+	if err != nil || prev.Driver {
+		t.Fatalf("driver counters should not be available: %+v, %v", prev, err)
+	}
+
+	if _, err := w.Write(testRWData); err != nil {
+		t.Fatalf("write data failed: %v", err)
+	}
+
+	if _, err := io.ReadFull(r, make([]byte, len(testRWData))); err != nil {
+		t.Fatalf("read data failed: %v", err)
+	}
+
+	if i, err := r.Read(make([]byte, 128)); err != io.EOF || i != 0 {
+		t.Errorf("read timeout failed: err = %v, i = %v", err, i)
+	}
+
+	c, err := r.Counters()
+	if err != nil {
+		t.Fatalf("get counters failed: %v", err)
+	}
+
+	delta := c.Delta(prev)
+	if delta.BytesRead != uint64(len(testRWData)) || delta.ReadTimeouts != 1 {
+		t.Errorf("read counters not correct: %+v", delta)
+	}
+
+	if c, _ := w.Counters(); c.BytesWritten != uint64(len(testRWData)) || c.WriteTimeouts != 0 {
+		t.Errorf("write counters not correct: %+v", c)
+	}
+}
+
+func TestSerialPort_CanonicalMode(t *testing.T) {
+	config := &CanonicalConfig{EOF: 0x04, EOL: '\r', Erase: 0x7f, Kill: 0x15}
+	options := append([]Option{WithCanonicalMode(config)}, baseOptions...)
+	r, w := getSerialPort(t, options)
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	if c, err := r.Config(); err != nil || !reflect.DeepEqual(c.Canonical, config) {
+		t.Errorf("canonical config not correct: %+v, %v", c.Canonical, err)
+	}
+
+	if _, err := w.Write([]byte("goiiot\nlibx\x7fserial\r")); err != nil {
+		t.Fatalf("write data failed: %v", err)
+	}
+
+	for _, line := range []string{"goiiot\n", "libserial\r"} {
+		buf := make([]byte, 128)
+		n, err := r.Read(buf)
+		if err != nil || string(buf[:n]) != line {
+			t.Errorf("read line failed: %q != %q, err = %v", buf[:n], line, err)
+		}
+	}
+}
+
+func TestTranslation(t *testing.T) {
+	for _, c := range []struct {
+		t        InputTranslation
+		input    string
+		expected string
+	}{
+		{0, "a\r\nb\xe1", "a\r\nb\xe1"},
+		{InputCRToNL, "a\r\nb\r", "a\n\nb\n"},
+		{InputNLToCR, "a\r\nb", "a\r\rb"},
+		{InputIgnoreCR | InputCRToNL, "a\r\nb\r", "a\nb"},
+		{InputStrip, "a\xe1\x8d", "aa\r"},
+		{InputStrip | InputIgnoreCR, "\x8d\r", ""},
+	} {
+		data := []byte(c.input)
+		if n := translateInput(c.t, data); string(data[:n]) != c.expected {
+			t.Errorf("input translation %b not correct: %q != %q", c.t, data[:n], c.expected)
+		}
+	}
+
+	out, ends := translateOutput(OutputNLToCRNL|OutputCRToNL, []byte("a\nb\r"))
+	if string(out) != "a\r\nb\n" || !reflect.DeepEqual(ends, []int{1, 3, 4, 5}) {
+		t.Errorf("output translation not correct: %q, %v", out, ends)
+	}
+
+	// NL is not written until both CR and NL written
+	for n, expected := range []int{0, 1, 1, 2, 3, 4} {
+		if written := translatedWritten(ends, n); written != expected {
+			t.Errorf("written bytes of %d translated not correct: %d != %d", n, written, expected)
+		}
+	}
+}
+
+func TestSerialPort_Translation(t *testing.T) {
+	r, w := getSerialPort(t, baseOptions)
+	defer func() {
+		r.Close()
+		w.Close()
+	}()
+
+	if err := r.Apply(WithInputTranslation(InputIgnoreCR)); err != nil {
+		t.Fatalf("apply input translation failed: %v", err)
+	}
+
+	if err := w.Apply(WithOutputTranslation(OutputNLToCRNL)); err != nil {
+		t.Fatalf("apply output translation failed: %v", err)
+	}
+
+	if c, err := r.Config(); err != nil || c.InputTranslation != InputIgnoreCR {
+		t.Errorf("input translation not correct: %v, %v", c.InputTranslation, err)
+	}
+
+	if c, err := w.Config(); err != nil || c.OutputTranslation != OutputNLToCRNL {
+		t.Errorf("output translation not correct: %v, %v", c.OutputTranslation, err)
+	}
+
+	// CR added by output translation is ignored by input translation
+	if _, err := w.Write([]byte("goiiot\rlibserial\n")); err != nil {
+		t.Fatalf("write data failed: %v", err)
+	}
+
+	expected := []byte("goiiotlibserial\n")
+	buf := make([]byte, len(expected))
+	if _, err := io.ReadFull(r, buf); err != nil || !bytes.Equal(buf, expected) {
+		t.Errorf("read translated data failed: %q != %q, err = %v", buf, expected, err)
+	}
+}
+
+func TestSerialPort_DrainTimeout(t *testing.T) {
+	// ptys have no output buffer to drain, use a fake stalled line
+	var (
+		drains   int32
+		released = make(chan struct{})
+	)
+
+	s := &SerialPort{
+		drain: func() error {
+			atomic.AddInt32(&drains, 1)
+			<-released
+			return nil
+		},
+		flush: func(d flushDirection) error {
+			t.Errorf("output should not be discarded by drain")
+			return nil
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		if err := s.DrainTimeout(100 * time.Millisecond); err != ErrDrainTimeout {
+			t.Errorf("drain stalled line should fail with ErrDrainTimeout: %v", err)
+		}
+
+		if d := time.Since(start); d < 100*time.Millisecond || d > time.Second {
+			t.Errorf("drain timeout not correct: %v", d)
+		}
+	}
+
+	// pending drain is reused after timeout
+	if n := atomic.LoadInt32(&drains); n != 1 {
+		t.Errorf("pending drain should be reused: %v drains", n)
+	}
+
+	close(released)
+	if err := s.Drain(); err != nil {
+		t.Errorf("drain failed: %v", err)
+	}
+}
+
+func TestWithParity(t *testing.T) {
+	for _, p := range []Parity{ParityNone, ParityOdd, ParityEven, ParityMark, ParitySpace} {
+		// parity set before is cleared
+		s := &SerialPort{}
+		if err := WithParity(ParityOdd)(s); err != nil {
+			t.Fatalf("set parity failed: %v", err)
+		}
+
+		if err := WithParity(p)(s); err != nil {
+			t.Errorf("set parity %v failed: %v", p, err)
+			continue
+		}
+
+		// ParityMark and ParitySpace are the same as ParityNone where they are zero
+		expected := uint64(0)
+		if p != ParityNone {
+			expected = uint64(p) | uint64(parityEnable)
+		}
+
+		if s.controlOptions != expected {
+			t.Errorf("parity flags of %v not correct: %#x != %#x", p, s.controlOptions, expected)
+		}
+	}
+}
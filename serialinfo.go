@@ -0,0 +1,153 @@
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// SerialFlag is a bit set of serial port flags (ASYNC_* in linux/tty_flags.h)
+type SerialFlag uint32
+
+// serial port flags
+const (
+	// SerialFlagSpdCust uses CustomDivisor for 38400 baud
+	SerialFlagSpdCust SerialFlag = 0x0030
+	// SerialFlagSkipTest skips UART test during autoconfiguration
+	SerialFlagSkipTest SerialFlag = 0x0040
+	// SerialFlagLowLatency requests low latency processing of received data
+	SerialFlagLowLatency SerialFlag = 0x2000
+)
+
+// ClosingWaitInfinite waits until all output sent when closing
+const ClosingWaitInfinite time.Duration = -1
+
+// max closing wait, 65535 means no wait (ASYNC_CLOSING_WAIT_NONE)
+const maxClosingWait = 65534 * 10 * time.Millisecond
+
+// UARTType is the type of UART (PORT_* in linux/serial.h)
+type UARTType int
+
+var uartTypeNames = []string{
+	"unknown", "8250", "16450", "16550", "16550A", "Cirrus", "16650",
+	"16650V2", "16750", "Startech", "16C950", "16654", "16850", "RSA",
+}
+
+func (t UARTType) String() string {
+	if t >= 0 && int(t) < len(uartTypeNames) {
+		return uartTypeNames[t]
+	}
+	return fmt.Sprintf("UARTType(%d)", int(t))
+}
+
+// SerialInfo is the low level info of serial port (struct serial_struct),
+// changing Type and flags other than SerialFlagLowLatency and
+// SerialFlagSpdCust usually requires CAP_SYS_ADMIN
+type SerialInfo struct {
+	Type  UARTType
+	Flags SerialFlag
+	// XmitFIFOSize is the size of transmit FIFO of the UART
+	XmitFIFOSize int
+	// CustomDivisor is used for 38400 baud when SerialFlagSpdCust set
+	CustomDivisor int
+	// BaudBase is the UART clock divided by 16 (read only)
+	BaudBase int
+	// ClosingWait is the max time waiting for output sent when closing,
+	// 10ms precision, 0 means no wait, ClosingWaitInfinite waits forever
+	ClosingWait time.Duration
+}
+
+// SerialInfo returns low level info of serial port (TIOCGSERIAL)
+// only supported on linux with drivers supporting it
+func (s *SerialPort) SerialInfo() (SerialInfo, error) {
+	return s.getSerialInfo()
+}
+
+// SetSerialInfo changes low level info of serial port (TIOCSSERIAL)
+// only supported on linux with drivers supporting it
+func (s *SerialPort) SetSerialInfo(info SerialInfo) error {
+	if err := checkClosingWait(info.ClosingWait); err != nil {
+		return err
+	}
+	return s.setSerialInfo(info)
+}
+
+// WithLowLatency set SerialFlagLowLatency of the driver,
+// data received is pushed to readers without delay on some drivers
+// only supported on linux with drivers supporting it
+func WithLowLatency(enable bool) Option {
+	return func(c *SerialPort) error {
+		if runtime.GOOS != "linux" {
+			return ErrNotSupported
+		}
+
+		c.lowLatency = &enable
+		return nil
+	}
+}
+
+// WithClosingWait set the max time waiting for output sent when closing,
+// 10ms precision, 0 means no wait, ClosingWaitInfinite waits forever
+// only supported on linux with drivers supporting it
+func WithClosingWait(wait time.Duration) Option {
+	return func(c *SerialPort) error {
+		if runtime.GOOS != "linux" {
+			return ErrNotSupported
+		}
+
+		if err := checkClosingWait(wait); err != nil {
+			return err
+		}
+
+		c.closingWait = &wait
+		return nil
+	}
+}
+
+func checkClosingWait(wait time.Duration) error {
+	if wait < 0 && wait != ClosingWaitInfinite || wait > maxClosingWait {
+		return fmt.Errorf("invalid closing wait: %v", wait)
+	}
+	return nil
+}
+
+// configureSerialInfo applies serial info options if set
+func (s *SerialPort) configureSerialInfo() error {
+	if s.lowLatency == nil && s.closingWait == nil {
+		return nil
+	}
+
+	info, err := s.getSerialInfo()
+	if err != nil {
+		return err
+	}
+
+	if s.lowLatency != nil {
+		info.Flags &^= SerialFlagLowLatency
+		if *s.lowLatency {
+			info.Flags |= SerialFlagLowLatency
+		}
+	}
+
+	if s.closingWait != nil {
+		info.ClosingWait = *s.closingWait
+	}
+
+	return s.setSerialInfo(info)
+}
@@ -0,0 +1,119 @@
+// +build linux
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// closing wait values of serial_struct, in 1/100 seconds
+const (
+	closingWaitInf  = 0
+	closingWaitNone = 65535
+)
+
+// serialStruct is struct serial_struct in linux/serial.h
+type serialStruct struct {
+	Type          int32
+	Line          int32
+	Port          uint32
+	IRQ           int32
+	Flags         int32
+	XmitFIFOSize  int32
+	CustomDivisor int32
+	BaudBase      int32
+	CloseDelay    uint16
+	IOType        uint8
+	reservedChar  uint8
+	Hub6          int32
+	ClosingWait   uint16
+	ClosingWait2  uint16
+	IOMemBase     uintptr
+	IOMemRegShift uint16
+	PortHigh      uint32
+	IOMapBase     uintptr
+}
+
+// getSerialInfo gets serial info with TIOCGSERIAL
+func (s *SerialPort) getSerialInfo() (SerialInfo, error) {
+	ss := &serialStruct{}
+	if err := ioctl(s.f.Fd(), unix.TIOCGSERIAL, uintptr(unsafe.Pointer(ss))); err != nil {
+		return SerialInfo{}, fmt.Errorf("fail to get serial info: %v", err)
+	}
+	return decodeSerialInfo(ss), nil
+}
+
+// setSerialInfo sets serial info with TIOCSSERIAL,
+// fields not in SerialInfo are kept unchanged
+func (s *SerialPort) setSerialInfo(info SerialInfo) error {
+	ss := &serialStruct{}
+	if err := ioctl(s.f.Fd(), unix.TIOCGSERIAL, uintptr(unsafe.Pointer(ss))); err != nil {
+		return fmt.Errorf("fail to get serial info: %v", err)
+	}
+
+	encodeSerialInfo(ss, &info)
+	if err := ioctl(s.f.Fd(), unix.TIOCSSERIAL, uintptr(unsafe.Pointer(ss))); err != nil {
+		return fmt.Errorf("fail to set serial info: %v", err)
+	}
+	return nil
+}
+
+// encodeSerialInfo updates serial_struct with info
+func encodeSerialInfo(ss *serialStruct, info *SerialInfo) {
+	ss.Type = int32(info.Type)
+	ss.Flags = int32(info.Flags)
+	ss.XmitFIFOSize = int32(info.XmitFIFOSize)
+	ss.CustomDivisor = int32(info.CustomDivisor)
+
+	switch info.ClosingWait {
+	case ClosingWaitInfinite:
+		ss.ClosingWait = closingWaitInf
+	case 0:
+		ss.ClosingWait = closingWaitNone
+	default:
+		// round up to 1/100 seconds
+		ss.ClosingWait = uint16((info.ClosingWait + 10*time.Millisecond - 1) / (10 * time.Millisecond))
+	}
+}
+
+// decodeSerialInfo decodes info from serial_struct
+func decodeSerialInfo(ss *serialStruct) SerialInfo {
+	info := SerialInfo{
+		Type:          UARTType(ss.Type),
+		Flags:         SerialFlag(ss.Flags),
+		XmitFIFOSize:  int(ss.XmitFIFOSize),
+		CustomDivisor: int(ss.CustomDivisor),
+		BaudBase:      int(ss.BaudBase),
+	}
+
+	switch ss.ClosingWait {
+	case closingWaitInf:
+		info.ClosingWait = ClosingWaitInfinite
+	case closingWaitNone:
+		info.ClosingWait = 0
+	default:
+		info.ClosingWait = time.Duration(ss.ClosingWait) * 10 * time.Millisecond
+	}
+
+	return info
+}
@@ -0,0 +1,73 @@
+// +build linux
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestSerialInfo(t *testing.T) {
+	expectedSize := uintptr(60)
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		expectedSize = 72
+	}
+	if size := unsafe.Sizeof(serialStruct{}); size != expectedSize {
+		t.Fatalf("size of serial_struct not correct: %v", size)
+	}
+
+	for _, c := range []struct {
+		wait    time.Duration
+		encoded uint16
+		decoded time.Duration
+	}{
+		{0, 65535, 0},
+		{ClosingWaitInfinite, 0, ClosingWaitInfinite},
+		{30 * time.Second, 3000, 30 * time.Second},
+		{15 * time.Millisecond, 2, 20 * time.Millisecond},
+	} {
+		ss := &serialStruct{Line: 1, BaudBase: 115200}
+		info := SerialInfo{
+			Type:          4,
+			Flags:         SerialFlagLowLatency,
+			XmitFIFOSize:  16,
+			CustomDivisor: 3,
+			ClosingWait:   c.wait,
+		}
+		encodeSerialInfo(ss, &info)
+
+		if ss.ClosingWait != c.encoded || ss.Flags != 0x2000 || ss.Line != 1 {
+			t.Errorf("serial_struct not correct: %+v", ss)
+		}
+
+		info.BaudBase, info.ClosingWait = 115200, c.decoded
+		if decoded := decodeSerialInfo(ss); decoded != info {
+			t.Errorf("serial info not correct: %+v != %+v", decoded, info)
+		}
+	}
+
+	if UARTType(4).String() != "16550A" {
+		t.Errorf("uart type name not correct: %v", UARTType(4))
+	}
+
+	if err := WithClosingWait(-time.Second)(&SerialPort{}); err == nil {
+		t.Errorf("negative closing wait should be rejected")
+	}
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"runtime"
+)
+
+// InputTranslation is a bit set of input character translations,
+// performed by the kernel on posix, and in userspace on windows
+type InputTranslation uint32
+
+const (
+	// InputCRToNL translates CR to NL (ICRNL)
+	InputCRToNL InputTranslation = 1 << iota
+	// InputNLToCR translates NL to CR (INLCR)
+	InputNLToCR
+	// InputIgnoreCR ignores CR (IGNCR)
+	InputIgnoreCR
+	// InputStrip strips the eighth bit (ISTRIP)
+	InputStrip
+	// InputIgnoreBreak ignores break condition (IGNBRK), posix only
+	InputIgnoreBreak
+	// InputBreakInterrupt flushes queues and sends SIGINT to the foreground
+	// process group on break condition (BRKINT), posix only
+	InputBreakInterrupt
+)
+
+// OutputTranslation is a bit set of output character translations,
+// performed by the kernel on posix, and in userspace on windows
+type OutputTranslation uint32
+
+const (
+	// OutputNLToCRNL translates NL to CR-NL (ONLCR)
+	OutputNLToCRNL OutputTranslation = 1 << iota
+	// OutputCRToNL translates CR to NL (OCRNL)
+	OutputCRToNL
+)
+
+// WithInputTranslation set input character translations, replacing
+// the ones set before, 0 means no translation
+// InputIgnoreBreak and InputBreakInterrupt are not supported on windows
+func WithInputTranslation(t InputTranslation) Option {
+	return func(c *SerialPort) error {
+		if t&(InputIgnoreBreak|InputBreakInterrupt) != 0 && runtime.GOOS == "windows" {
+			return ErrNotSupported
+		}
+
+		c.inputTranslation = t
+		return nil
+	}
+}
+
+// WithOutputTranslation set output character translations, replacing
+// the ones set before, 0 means no translation
+func WithOutputTranslation(t OutputTranslation) Option {
+	return func(c *SerialPort) error {
+		c.outputTranslation = t
+		return nil
+	}
+}
+
+// translateInput translates input bytes in place like the tty line discipline,
+// returns count of bytes after translation
+func translateInput(t InputTranslation, data []byte) int {
+	n := 0
+	for _, b := range data {
+		if t&InputStrip != 0 {
+			b &= 0x7f
+		}
+
+		switch {
+		case b == '\r' && t&InputIgnoreCR != 0:
+			continue
+		case b == '\r' && t&InputCRToNL != 0:
+			b = '\n'
+		case b == '\n' && t&InputNLToCR != 0:
+			b = '\r'
+		}
+
+		data[n] = b
+		n++
+	}
+
+	return n
+}
+
+// translateOutput returns output bytes after translation,
+// and the offsets in translated bytes where each input byte ends
+func translateOutput(t OutputTranslation, data []byte) (out []byte, ends []int) {
+	out = make([]byte, 0, len(data))
+	ends = make([]int, len(data))
+	for i, b := range data {
+		switch {
+		case b == '\n' && t&OutputNLToCRNL != 0:
+			out = append(out, '\r', '\n')
+		case b == '\r' && t&OutputCRToNL != 0:
+			out = append(out, '\n')
+		default:
+			out = append(out, b)
+		}
+		ends[i] = len(out)
+	}
+
+	return out, ends
+}
+
+// translatedWritten returns count of input bytes fully written
+// when n translated bytes written
+func translatedWritten(ends []int, n int) int {
+	i := 0
+	for i < len(ends) && ends[i] <= n {
+		i++
+	}
+	return i
+}
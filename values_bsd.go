@@ -18,24 +18,68 @@
 
 package libserial
 
-import "golang.org/x/sys/unix"
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
 
 const (
-	termiosReqGet = uint(unix.TIOCGETA)
-	termiosReqSet = uint(unix.TIOCSETA)
-	maskBaudRate  = uint64(0)
-	ParityMark    = Parity(0)
-	ParitySpace   = Parity(0)
+	termiosReqGet      = uint(unix.TIOCGETA)
+	termiosReqSet      = uint(unix.TIOCSETA)
+	termiosReqSetDrain = uint(unix.TIOCSETAW)
+	termiosReqSetFlush = uint(unix.TIOCSETAF)
+	ParityMark         = Parity(0)
+	ParitySpace        = Parity(0)
+	flushRead          = 0x1 // FREAD in sys/fcntl.h
+	flushWrite         = 0x2 // FWRITE in sys/fcntl.h
+	queueReqInput      = uint(fionread)
+	queueReqOutput     = uint(unix.TIOCOUTQ)
+	// _POSIX_VDISABLE in sys/termios.h
+	posixVDisable = 0xff
 )
 
-func mkFlushFunc(fd uintptr) func() error {
-	return func() error {
-		tty, err := unix.IoctlGetTermios(int(fd), termiosReqGet)
-		if err != nil {
-			return err
+// FIONREAD is not defined for bsd in golang.org/x/sys/unix,
+// encode it like _IOR('f', 127, int) in sys/ioccom.h
+const (
+	iocOut      = 0x40000000
+	iocParmMask = 0x1fff
+	fionread    = iocOut | (4&iocParmMask)<<16 | 'f'<<8 | 127
+)
+
+// check the encoding with TIOCOUTQ, _IOR('t', 115, int), at compile time
+var _ = [1]struct{}{}[unix.TIOCOUTQ-(iocOut|(4&iocParmMask)<<16|'t'<<8|115)]
+
+func mkFlushFunc(fd uintptr) func(d flushDirection) error {
+	return func(d flushDirection) error {
+		queue := int32(0)
+		if d&flushInput != 0 {
+			queue |= flushRead
+		}
+
+		if d&flushOutput != 0 {
+			queue |= flushWrite
 		}
 
-		// set serial port again for input/output flush
-		return unix.IoctlSetTermios(int(fd), unix.TIOCSETAF, tty)
+		return ioctl(fd, unix.TIOCFLUSH, uintptr(unsafe.Pointer(&queue)))
 	}
 }
+
+func mkDrainFunc(fd uintptr) func() error {
+	return func() error {
+		// tcdrain
+		return ioctl(fd, unix.TIOCDRAIN, 0)
+	}
+}
+
+// setTermiosSpeed set input and output baud rate of tty,
+// baud rate constants are the same as their values on bsd
+func setTermiosSpeed(tty *unix.Termios, input, output uint64) {
+	tty.Ispeed = termiosSpeedType(input)
+	tty.Ospeed = termiosSpeedType(output)
+}
+
+// getTermiosSpeed get input and output baud rate of tty
+func getTermiosSpeed(tty *unix.Termios) (input, output uint64) {
+	return uint64(tty.Ispeed), uint64(tty.Ospeed)
+}
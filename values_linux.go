@@ -26,16 +26,25 @@ type termiosFlagType = uint32
 type termiosSpeedType = uint32
 
 const (
-	termiosReqGet = uint(unix.TCGETS)
-	termiosReqSet = uint(unix.TCSETS)
-	maskBaudRate  = uint64(unix.CBAUD)
-	ParityMark    = Parity(unix.CMSPAR)
-	ParitySpace   = 0
+	ParityMark     = Parity(unix.CMSPAR)
+	ParitySpace    = 0
+	queueReqInput  = uint(unix.TIOCINQ)
+	queueReqOutput = uint(unix.TIOCOUTQ)
+	// _POSIX_VDISABLE in linux/tty.h
+	posixVDisable = 0
 )
 
-func mkFlushFunc(fd uintptr) func() error {
-	return func() error {
-		r, _, err := unix.Syscall(unix.SYS_IOCTL, fd, unix.TCFLSH, unix.TCIOFLUSH)
+func mkFlushFunc(fd uintptr) func(d flushDirection) error {
+	return func(d flushDirection) error {
+		queue := unix.TCIOFLUSH
+		switch d {
+		case flushInput:
+			queue = unix.TCIFLUSH
+		case flushOutput:
+			queue = unix.TCOFLUSH
+		}
+
+		r, _, err := unix.Syscall(unix.SYS_IOCTL, fd, unix.TCFLSH, uintptr(queue))
 		if r == 0 {
 			return nil
 		}
@@ -43,6 +52,35 @@ func mkFlushFunc(fd uintptr) func() error {
 	}
 }
 
+func mkDrainFunc(fd uintptr) func() error {
+	return func() error {
+		// tcdrain
+		return ioctl(fd, unix.TCSBRK, 1)
+	}
+}
+
+// setTermiosSpeed set input and output baud rate of tty, Bxxx constants are
+// used for standard baud rates and BOTHER with raw speed value for the others
+func setTermiosSpeed(tty *unix.Termios, input, output uint64) {
+	tty.Cflag &= ^termiosFlagType(unix.CBAUD | unix.CIBAUD)
+	tty.Cflag |= baudRateFlag(output) | baudRateFlag(input)<<unix.IBSHIFT
+	tty.Ispeed = termiosSpeedType(input)
+	tty.Ospeed = termiosSpeedType(output)
+}
+
+// getTermiosSpeed get input and output baud rate of tty,
+// the speed values are always filled by kernel for termios2
+func getTermiosSpeed(tty *unix.Termios) (input, output uint64) {
+	return uint64(tty.Ispeed), uint64(tty.Ospeed)
+}
+
+func baudRateFlag(rate uint64) termiosFlagType {
+	if baudRate, ok := validBaudRates[int(rate)]; ok {
+		return baudRate
+	}
+	return unix.BOTHER
+}
+
 var validBaudRates = map[int]uint32{
 	0:       unix.B0, // detect baud rate automatically
 	50:      unix.B50,
@@ -0,0 +1,29 @@
+// +build linux,ppc64 linux,ppc64le
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import "golang.org/x/sys/unix"
+
+// termios on powerpc has speed fields already, and there is no termios2
+const (
+	termiosReqGet      = uint(unix.TCGETS)
+	termiosReqSet      = uint(unix.TCSETS)
+	termiosReqSetDrain = uint(unix.TCSETSW)
+	termiosReqSetFlush = uint(unix.TCSETSF)
+)
@@ -0,0 +1,29 @@
+// +build linux,!ppc64,!ppc64le
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import "golang.org/x/sys/unix"
+
+// use termios2 to set arbitrary baud rates
+const (
+	termiosReqGet      = uint(unix.TCGETS2)
+	termiosReqSet      = uint(unix.TCSETS2)
+	termiosReqSetDrain = uint(unix.TCSETSW2)
+	termiosReqSetFlush = uint(unix.TCSETSF2)
+)
@@ -0,0 +1,64 @@
+// +build linux
+
+/*
+ * Copyright Go-IIoT (https://github.com/goiiot)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libserial
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestWithBaudRate_NonStandard(t *testing.T) {
+	for _, rate := range []int{250000, 31250, 10400, 74880, 115200} {
+		a, b, err := OpenPTYPair(WithBaudRate(rate))
+		if err != nil {
+			t.Fatalf("open pty pair with baud rate %v failed: %v", rate, err)
+		}
+
+		tty, err := unix.IoctlGetTermios(int(a.f.Fd()), termiosReqGet)
+		if err != nil {
+			t.Errorf("get termios failed: %v", err)
+		} else if tty.Ispeed != uint32(rate) || tty.Ospeed != uint32(rate) {
+			t.Errorf("target: %v, result: input %v, output %v", rate, tty.Ispeed, tty.Ospeed)
+		}
+
+		a.Close()
+		b.Close()
+	}
+}
+
+func TestWithBaudRate_Separated(t *testing.T) {
+	a, b, err := OpenPTYPair(WithInputBaudRate(1200), WithOutputBaudRate(250000))
+	if err != nil {
+		t.Fatalf("open pty pair failed: %v", err)
+	}
+	defer func() {
+		a.Close()
+		b.Close()
+	}()
+
+	tty, err := unix.IoctlGetTermios(int(a.f.Fd()), termiosReqGet)
+	if err != nil {
+		t.Fatalf("get termios failed: %v", err)
+	}
+
+	if tty.Ispeed != 1200 || tty.Ospeed != 250000 {
+		t.Errorf("target: input 1200, output 250000, result: input %v, output %v", tty.Ispeed, tty.Ospeed)
+	}
+}
@@ -31,8 +31,15 @@ const (
 	serialFileFlag   = unix.O_RDWR | unix.O_NOCTTY | unix.O_NONBLOCK
 	softwareCtrlFlag = unix.IXON | unix.IXOFF | unix.IXANY
 	hardwareCtrlFlag = unix.CRTSCTS
+	markErrorFlag    = unix.INPCK | unix.PARMRK
 	dataBits5        = unix.CS5
 	dataBits6        = unix.CS6
 	dataBits7        = unix.CS7
 	dataBits8        = unix.CS8
+	ModemLineDTR     = ModemLine(unix.TIOCM_DTR)
+	ModemLineRTS     = ModemLine(unix.TIOCM_RTS)
+	ModemLineCTS     = ModemLine(unix.TIOCM_CTS)
+	ModemLineDSR     = ModemLine(unix.TIOCM_DSR)
+	ModemLineDCD     = ModemLine(unix.TIOCM_CAR)
+	ModemLineRI      = ModemLine(unix.TIOCM_RNG)
 )
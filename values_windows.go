@@ -34,7 +34,6 @@ const (
 	StopBitTwo       StopBit = 2
 	_dcbSize                 = uint32(unsafe.Sizeof(_dcb{}))
 	maskDataBits             = uint64(0)
-	maskBaudRate             = uint64(0)
 	softwareCtrlFlag         = 0
 	hardwareCtrlFlag         = 0
 	parityEnable             = 0
@@ -44,6 +43,18 @@ const (
 	dataBits8                = 0
 )
 
+// line errors are not marked in the stream on windows
+const markErrorFlag = 0
+
+const (
+	ModemLineDTR ModemLine = 0x0100 // not reported by GetCommModemStatus
+	ModemLineRTS ModemLine = 0x0200 // not reported by GetCommModemStatus
+	ModemLineCTS ModemLine = 0x0010 // MS_CTS_ON
+	ModemLineDSR ModemLine = 0x0020 // MS_DSR_ON
+	ModemLineRI  ModemLine = 0x0040 // MS_RING_ON
+	ModemLineDCD ModemLine = 0x0080 // MS_RLSD_ON
+)
+
 type _dcb struct {
 	DCBLength, BaudRate                            uint32
 	flags                                          [4]byte
@@ -53,5 +64,19 @@ type _dcb struct {
 	wReserved1                                     uint16
 }
 
+type _commTimeouts struct {
+	ReadIntervalTimeout         uint32
+	ReadTotalTimeoutMultiplier  uint32
+	ReadTotalTimeoutConstant    uint32
+	WriteTotalTimeoutMultiplier uint32
+	WriteTotalTimeoutConstant   uint32
+}
+
+type _comStat struct {
+	flags    uint32
+	cbInQue  uint32
+	cbOutQue uint32
+}
+
 // ignored in windows
 var validBaudRates map[int]uint32